@@ -0,0 +1,280 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	tm_config "tablet_mapper/config"
+	tm_inputs "tablet_mapper/inputs"
+	"tablet_mapper/outputs"
+	"tablet_mapper/windows"
+)
+
+// screenState is what mapping targets are resolved against.
+type screenState struct {
+	windowList []windows.Window
+	focused    windows.Window
+	layout     outputs.Layout
+	desktop    int
+}
+
+func readScreenState(focusedId string) screenState {
+	state := screenState{windowList: windows.GetWindowList()}
+	state.focused, _ = windows.FindWindowById(state.windowList, focusedId)
+	var err error
+	if state.layout, err = outputs.GetLayout(); err != nil {
+		log.Printf("WARN: %s", err.Error())
+	}
+	if state.desktop, err = windows.GetCurrentDesktop(); err != nil {
+		log.Printf("WARN: %s", err.Error())
+	}
+	return state
+}
+
+// resolveTarget computes the coordinate matrix for target. Window targets
+// without a window name map to the focused window.
+func resolveTarget(target tm_inputs.MappingTarget, state screenState) (tm_inputs.CoordinationMatrix, error) {
+	rotation := tm_inputs.GetCoordinateMatrix(target.Rotation)
+	switch target.MappingType {
+	case tm_inputs.INPUT_MAPPING_COORD_MATRIX:
+		return target.CoordMatrix, nil
+	case tm_inputs.INPUT_MAPPING_DESKTOP:
+		return rotation, nil
+	case tm_inputs.INPUT_MAPPING_OUTPUT:
+		output, ok := state.layout.FindOutput(target.OutputName)
+		if !ok {
+			return tm_inputs.CoordinationMatrix{}, fmt.Errorf("No connected output '%s'", target.OutputName)
+		}
+		log.Printf("INFO: mapping to output %+v", output)
+		return state.layout.GetCoordMappingForRect(output.Rect).MultiplyCoordMatrices(rotation), nil
+	case tm_inputs.INPUT_MAPPING_REGION:
+		region, err := outputs.ParseRegion(target.Region)
+		if err != nil {
+			return tm_inputs.CoordinationMatrix{}, err
+		}
+		rect, err := state.layout.RegionRect(region)
+		if err != nil {
+			return tm_inputs.CoordinationMatrix{}, err
+		}
+		log.Printf("INFO: mapping to region %s at %s", target.Region, rect.Geometry())
+		return state.layout.GetCoordMappingForRect(rect).MultiplyCoordMatrices(rotation), nil
+	case tm_inputs.INPUT_MAPPING_WINDOW:
+		if target.WindowName == "" {
+			if state.focused.Id == "" {
+				return tm_inputs.CoordinationMatrix{}, fmt.Errorf("No focused window to map to")
+			}
+			return windowMatrix(state.focused, target, state.layout)
+		}
+		found := false
+		for _, window := range state.windowList {
+			if window.AppName != target.WindowName {
+				continue
+			}
+			found = true
+			if window.IsVisible(state.desktop) {
+				return windowMatrix(window, target, state.layout)
+			}
+		}
+		if found {
+			return tm_inputs.CoordinationMatrix{}, fmt.Errorf("Window '%s' is minimized or on another desktop", target.WindowName)
+		}
+		return tm_inputs.CoordinationMatrix{}, fmt.Errorf("No window named '%s'", target.WindowName)
+	}
+	return tm_inputs.CoordinationMatrix{}, fmt.Errorf("Unknown mapping type '%s'", target.MappingType)
+}
+
+// resolveWithFallbacks resolves target or else the first of the input's
+// fallback targets which can be resolved.
+func resolveWithFallbacks(config tm_inputs.InputConfig, target tm_inputs.MappingTarget, state screenState) (tm_inputs.CoordinationMatrix, error) {
+	coordMatrix, err := resolveTarget(target, state)
+	if err == nil {
+		return coordMatrix, nil
+	}
+	log.Printf("WARN: %s, trying fallbacks", err.Error())
+	for _, fallback := range config.FallbackTargets() {
+		coordMatrix, fallbackErr := resolveTarget(fallback, state)
+		if fallbackErr == nil {
+			log.Printf("INFO: falling back to %s mapping", fallback.MappingType)
+			return coordMatrix, nil
+		}
+		log.Printf("WARN: %s", fallbackErr.Error())
+	}
+	return tm_inputs.CoordinationMatrix{}, fmt.Errorf("No fallback could be applied. %w", err)
+}
+
+// windowMatrix maps to the window, clipped to the visible screen according
+// to the target's clip mode.
+func windowMatrix(window windows.Window, target tm_inputs.MappingTarget, layout outputs.Layout) (tm_inputs.CoordinationMatrix, error) {
+	log.Printf("INFO: mapping to window %+v", window)
+	if layout.Width == 0 || layout.Height == 0 {
+		return tm_inputs.CoordinationMatrix{}, fmt.Errorf("Unknown screen size")
+	}
+	rect := window.Rect()
+	if target.Clip != tm_inputs.INPUT_CLIP_NONE {
+		clipped, ok := rect, true
+		switch target.Clip {
+		case tm_inputs.INPUT_CLIP_SCREEN:
+			clipped, ok = layout.ClipToOutputs(rect)
+		case tm_inputs.INPUT_CLIP_MONITOR:
+			clipped, ok = layout.ClipToLargestOutput(rect)
+		default:
+			return tm_inputs.CoordinationMatrix{}, fmt.Errorf("Unknown clip mode '%s'", target.Clip)
+		}
+		if !ok {
+			return tm_inputs.CoordinationMatrix{}, fmt.Errorf("Window '%s' is off screen", window.AppName)
+		}
+		if clipped != rect {
+			log.Printf("WARN: window '%s' at %+v reaches past the visible screen, clipped to %+v", window.AppName, rect, clipped)
+		}
+		rect = clipped
+	}
+	coordMatrix := layout.GetCoordMappingForRect(rect)
+	return coordMatrix.MultiplyCoordMatrices(tm_inputs.GetCoordinateMatrix(target.Rotation)), nil
+}
+
+// targetFor returns the target of the first rule matching the focused window
+// and current desktop or the configured target if no rule matches.
+func targetFor(config tm_inputs.InputConfig, state screenState) tm_inputs.MappingTarget {
+	for _, rule := range config.Rules {
+		if state.focused.Matches(rule.Match, state.desktop) {
+			return rule.MappingTarget
+		}
+	}
+	return config.Target()
+}
+
+// applyArea maps the input to its target for the current state. The area is
+// only re-mapped when the resulting matrix differs from the one in
+// appliedAreas. It returns false if the input couldn't be mapped.
+func applyArea(input tm_inputs.Input, state screenState, appliedAreas map[string]tm_inputs.CoordinationMatrix) bool {
+	if input.Config.MappingType == "" && len(input.Config.Rules) == 0 {
+		return true
+	}
+	coordMatrix, err := resolveWithFallbacks(input.Config, targetFor(input.Config, state), state)
+	if err != nil {
+		log.Printf("WARN: couldn't map '%s'. %s", input.Name, err.Error())
+		return false
+	}
+	if applied, ok := appliedAreas[input.Name]; ok && applied == coordMatrix {
+		return true
+	}
+	if err := input.MapToArea(coordMatrix); err != nil {
+		log.Printf("WARN: couldn't map '%s'. %s", input.Name, err.Error())
+		return false
+	}
+	appliedAreas[input.Name] = coordMatrix
+	return true
+}
+
+// applyDevices sets the config of every input found in devices and maps its
+// area and buttons. It returns the applied areas, and an error if any input
+// couldn't be mapped or none is configured.
+func applyDevices(inputs []tm_inputs.Input, devices tm_config.TabletMapperConfig) (map[string]tm_inputs.CoordinationMatrix, error) {
+	state := readScreenState("")
+	appliedAreas := make(map[string]tm_inputs.CoordinationMatrix)
+	configured, failed := 0, 0
+	for i := range inputs {
+		if _, ok := devices[inputs[i].Name]; ok {
+			configured++
+		}
+		if !applyDevice(&inputs[i], devices, state, appliedAreas) {
+			failed++
+		}
+	}
+	if configured == 0 {
+		return appliedAreas, fmt.Errorf("None of the connected inputs is configured")
+	}
+	if failed > 0 {
+		return appliedAreas, fmt.Errorf("Couldn't apply the config of %d inputs", failed)
+	}
+	return appliedAreas, nil
+}
+
+// applyChangedDevices is like applyDevices, but only touches the inputs
+// whose config in devices differs from their current one. It returns the
+// names of those inputs.
+func applyChangedDevices(inputs []tm_inputs.Input, devices tm_config.TabletMapperConfig, appliedAreas map[string]tm_inputs.CoordinationMatrix) []string {
+	state := readScreenState("")
+	changed := make([]string, 0)
+	for i := range inputs {
+		if reflect.DeepEqual(inputs[i].Config, devices[inputs[i].Name]) {
+			continue
+		}
+		log.Printf("INFO: config of '%s' changed", inputs[i].Name)
+		delete(appliedAreas, inputs[i].Name)
+		applyDevice(&inputs[i], devices, state, appliedAreas)
+		changed = append(changed, inputs[i].Name)
+	}
+	return changed
+}
+
+func applyDevice(input *tm_inputs.Input, devices tm_config.TabletMapperConfig, state screenState, appliedAreas map[string]tm_inputs.CoordinationMatrix) bool {
+	config, ok := devices[input.Name]
+	if !ok {
+		input.Config = tm_inputs.InputConfig{}
+		return true
+	}
+	input.Config = config
+	log.Printf("Input config: %v", input.Config)
+	if err := input.SetMode(); err != nil {
+		log.Printf("WARN: %s", err.Error())
+		ok = false
+	}
+	if err := input.SetTabletArea(); err != nil {
+		log.Printf("WARN: %s", err.Error())
+		ok = false
+	}
+	ok = applyArea(*input, state, appliedAreas) && ok
+	if err := input.MapButtons(); err != nil {
+		log.Printf("WARN: couldn't map buttons of '%s'. %s", input.Name, err.Error())
+		return false
+	}
+	return ok
+}
+
+// reloadConfig reads the effective config again after the config file
+// changed and selects the profile to use. ok is false if the new config
+// can't be read or has problems, in which case the current config should be
+// kept.
+func reloadConfig(confPath string, profileFlag string, explicitProfile bool) (doc tm_config.Document, name string, profile tm_config.Profile, ok bool) {
+	doc, origins, err := tm_config.ReadEffectiveDocument(confPath, profileFlag)
+	if err != nil {
+		log.Printf("ERROR: keeping the current config. %s", err.Error())
+		return doc, "", profile, false
+	}
+	if problems := tm_config.Validate(doc, origins); len(problems) > 0 {
+		for _, problem := range problems {
+			log.Printf("ERROR: %s", problem.Error())
+		}
+		log.Printf("ERROR: keeping the current config, found %d problems in the new one", len(problems))
+		return doc, "", profile, false
+	}
+	if name, profile, err = selectProfile(doc, explicitProfile); err != nil {
+		log.Printf("ERROR: keeping the current config. %s", err.Error())
+		return doc, "", profile, false
+	}
+	log.Printf("INFO: reloaded config %s, using profile '%s'", confPath, name)
+	return doc, name, profile, true
+}
+
+// selectProfile returns the active profile of the effective document. If
+// the profile wasn't chosen explicitly, the profile matching the monitor
+// layout takes precedence.
+func selectProfile(doc tm_config.Document, explicit bool) (string, tm_config.Profile, error) {
+	if !explicit {
+		if layout, err := outputs.GetLayout(); err != nil {
+			log.Printf("WARN: %s", err.Error())
+		} else if name, ok := doc.ProfileForLayout(layout); ok {
+			log.Printf("INFO: profile '%s' matches the monitor layout", name)
+			return doc.GetProfile(name)
+		}
+	}
+	return doc.GetProfile("")
+}
+
+// isProfileExplicit reports whether the profile is chosen by the --profile
+// flag or the environment.
+func isProfileExplicit(profileFlag string) bool {
+	return profileFlag != "" || os.Getenv(tm_config.ENV_PROFILE) != ""
+}
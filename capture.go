@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"slices"
+	tm_config "tablet_mapper/config"
+	tm_inputs "tablet_mapper/inputs"
+)
+
+// CAPTURE_TOLERANCE is how far matrix values may be off and still match a
+// target. xinput prints them with 6 decimals.
+const CAPTURE_TOLERANCE = 1e-4
+
+var captureRotations = []int{0, 90, 180, 270}
+
+var identityMatrix = tm_inputs.CoordinationMatrix{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}
+
+// captureDevices reads the current mapping, mode, tablet area and buttons of
+// every input into device configs. A tablet area covering the whole surface
+// isn't stored, as that is the default. Inputs for which nothing but an
+// untransformed mapping can be read, like plain pointers, are left out.
+func captureDevices(inputs []tm_inputs.Input, state screenState) tm_config.TabletMapperConfig {
+	devices := tm_config.TabletMapperConfig{}
+	for _, input := range inputs {
+		config, read := tm_inputs.InputConfig{}, 0
+		if m, err := input.GetArea(); err != nil {
+			log.Printf("WARN: %s", err.Error())
+		} else {
+			target := inferTarget(m, state)
+			config.MappingType = target.MappingType
+			config.WindowName = target.WindowName
+			config.OutputName = target.OutputName
+			config.CoordMatrix = target.CoordMatrix
+			config.Rotation = target.Rotation
+			if !matricesMatch(m, identityMatrix) {
+				read++
+			}
+		}
+		if mode, err := input.GetMode(); err != nil {
+			log.Printf("WARN: %s", err.Error())
+		} else {
+			config.Mode = mode
+			read++
+		}
+		if area, err := input.GetTabletArea(); err != nil {
+			log.Printf("WARN: %s", err.Error())
+		} else if !isFullTabletArea(input, area) {
+			config.TabletArea = area
+			read++
+		}
+		if buttons, err := input.GetButtons(); err != nil {
+			log.Printf("WARN: %s", err.Error())
+		} else {
+			config.Buttons = buttons
+			read++
+		}
+		if read > 0 {
+			devices[input.Name] = config
+		}
+	}
+	return devices
+}
+
+// isFullTabletArea reports whether area covers the whole tablet surface. It
+// is false if the surface can't be read.
+func isFullTabletArea(input tm_inputs.Input, area []int) bool {
+	full, err := input.GetFullTabletArea()
+	return err == nil && slices.Equal(area, full)
+}
+
+// inferTarget returns the target which maps to m in the current state:
+// the desktop, an output or a visible window, in any rotation. If none
+// does, m is kept as a transformation matrix.
+func inferTarget(m tm_inputs.CoordinationMatrix, state screenState) tm_inputs.MappingTarget {
+	candidates := make([]tm_inputs.MappingTarget, 0)
+	for _, rotation := range captureRotations {
+		candidates = append(candidates, tm_inputs.MappingTarget{MappingType: tm_inputs.INPUT_MAPPING_DESKTOP, Rotation: rotation})
+	}
+	for _, output := range state.layout.Outputs {
+		for _, rotation := range captureRotations {
+			candidates = append(candidates, tm_inputs.MappingTarget{MappingType: tm_inputs.INPUT_MAPPING_OUTPUT, OutputName: output.Name, Rotation: rotation})
+		}
+	}
+	seen := map[string]bool{}
+	for _, window := range state.windowList {
+		if window.AppName == "" || seen[window.AppName] || !window.IsVisible(state.desktop) {
+			continue
+		}
+		seen[window.AppName] = true
+		for _, rotation := range captureRotations {
+			candidates = append(candidates, tm_inputs.MappingTarget{MappingType: tm_inputs.INPUT_MAPPING_WINDOW, WindowName: window.AppName, Rotation: rotation})
+		}
+	}
+	for _, target := range candidates {
+		if resolved, err := resolveTarget(target, state); err == nil && matricesMatch(resolved, m) {
+			return target
+		}
+	}
+	return tm_inputs.MappingTarget{MappingType: tm_inputs.INPUT_MAPPING_COORD_MATRIX, CoordMatrix: m}
+}
+
+func matricesMatch(a, b tm_inputs.CoordinationMatrix) bool {
+	for i := range a {
+		for j := range a[i] {
+			if math.Abs(float64(a[i][j]-b[i][j])) > CAPTURE_TOLERANCE {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// captureSummary describes a captured device config in one line.
+func captureSummary(name string, config tm_inputs.InputConfig) string {
+	summary := fmt.Sprintf("%s: %s", name, describeTarget(config.Target()))
+	if config.Rotation != 0 {
+		summary += fmt.Sprintf(" rotated by %d", config.Rotation)
+	}
+	if config.Mode != "" {
+		summary += ", " + config.Mode
+	}
+	if len(config.TabletArea) > 0 {
+		summary += fmt.Sprintf(", tablet area %v", config.TabletArea)
+	}
+	return summary + fmt.Sprintf(", %d buttons", len(config.Buttons))
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	tm_inputs "tablet_mapper/inputs"
+	"tablet_mapper/outputs"
+	"tablet_mapper/windows"
+	"testing"
+)
+
+func TestInferTarget(t *testing.T) {
+	state := screenState{
+		layout: outputs.Layout{Width: 4480, Height: 1440, Outputs: []outputs.Output{
+			{Name: "DP-2", Rect: outputs.Rect{Width: 2560, Height: 1440}},
+			{Name: "HDMI-1", Primary: true, Rect: outputs.Rect{X: 2560, Width: 1920, Height: 1080}},
+		}},
+		windowList: []windows.Window{
+			{Id: "0x01", AppName: "krita", Xoffset: 100, Yoffset: 100, Width: 800, Height: 600},
+			{Id: "0x02", AppName: "gimp", DesktopId: 1, Xoffset: 0, Yoffset: 0, Width: 2240, Height: 1440},
+		},
+	}
+	// The matrix of the gimp window, which is on another desktop.
+	gimp := tm_inputs.CoordinationMatrix{{0.5, 0, 0}, {0, 1, 0}, {0, 0, 1}}
+	tests := []struct {
+		name string
+		m    tm_inputs.CoordinationMatrix
+		want tm_inputs.MappingTarget
+	}{
+		{
+			"desktop",
+			tm_inputs.CoordinationMatrix{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}},
+			tm_inputs.MappingTarget{MappingType: tm_inputs.INPUT_MAPPING_DESKTOP},
+		},
+		{
+			"rotated desktop",
+			tm_inputs.CoordinationMatrix{{-1, 0, 1}, {0, -1, 1}, {0, 0, 1}},
+			tm_inputs.MappingTarget{MappingType: tm_inputs.INPUT_MAPPING_DESKTOP, Rotation: 180},
+		},
+		{
+			"output",
+			tm_inputs.CoordinationMatrix{{1920.0 / 4480, 0, 2560.0 / 4480}, {0, 1080.0 / 1440, 0}, {0, 0, 1}},
+			tm_inputs.MappingTarget{MappingType: tm_inputs.INPUT_MAPPING_OUTPUT, OutputName: "HDMI-1"},
+		},
+		{
+			"rounded like xinput prints it",
+			tm_inputs.CoordinationMatrix{{0.571429, 0, 0}, {0, 1, 0}, {0, 0, 1}},
+			tm_inputs.MappingTarget{MappingType: tm_inputs.INPUT_MAPPING_OUTPUT, OutputName: "DP-2"},
+		},
+		{
+			"rotated window",
+			tm_inputs.CoordinationMatrix{{0, 800.0 / 4480, 100.0 / 4480}, {-600.0 / 1440, 0, 700.0 / 1440}, {0, 0, 1}},
+			tm_inputs.MappingTarget{MappingType: tm_inputs.INPUT_MAPPING_WINDOW, WindowName: "krita", Rotation: 90},
+		},
+		{
+			"window on another desktop",
+			gimp,
+			tm_inputs.MappingTarget{MappingType: tm_inputs.INPUT_MAPPING_COORD_MATRIX, CoordMatrix: gimp},
+		},
+		{
+			"no match",
+			tm_inputs.CoordinationMatrix{{0.3, 0, 0.1}, {0, 0.3, 0.1}, {0, 0, 1}},
+			tm_inputs.MappingTarget{MappingType: tm_inputs.INPUT_MAPPING_COORD_MATRIX, CoordMatrix: tm_inputs.CoordinationMatrix{{0.3, 0, 0.1}, {0, 0.3, 0.1}, {0, 0, 1}}},
+		},
+	}
+	for _, test := range tests {
+		got := inferTarget(test.m, state)
+		if got.MappingType != test.want.MappingType || got.OutputName != test.want.OutputName ||
+			got.WindowName != test.want.WindowName || got.Rotation != test.want.Rotation || got.CoordMatrix != test.want.CoordMatrix {
+			t.Errorf("%s: inferTarget = %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
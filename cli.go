@@ -0,0 +1,648 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	tm_config "tablet_mapper/config"
+	tm_inputs "tablet_mapper/inputs"
+	"tablet_mapper/outputs"
+	"tablet_mapper/windows"
+)
+
+// Exit codes of the commands.
+const (
+	EXIT_OK      = 0
+	EXIT_FAILURE = 1
+	EXIT_USAGE   = 2
+)
+
+// usageError is an invalid command line. It exits with EXIT_USAGE rather
+// than EXIT_FAILURE.
+type usageError struct {
+	error
+}
+
+func usageErrorf(format string, args ...any) error {
+	return usageError{fmt.Errorf(format, args...)}
+}
+
+// exitCode returns the exit code for the error a command returned.
+func exitCode(err error) int {
+	if err == nil || errors.Is(err, flag.ErrHelp) {
+		return EXIT_OK
+	}
+	if errors.As(err, &usageError{}) {
+		return EXIT_USAGE
+	}
+	return EXIT_FAILURE
+}
+
+// runCommand runs the command named by the first argument, opening the GUI
+// if there is none. profile is the value of the global --profile flag.
+func runCommand(args []string, profile string) error {
+	if len(args) == 0 {
+		return guiCommand(args, profile)
+	}
+	command, args := args[0], args[1:]
+	switch command {
+	case "gui":
+		return guiCommand(args, profile)
+	case "apply":
+		return applyCommand(args, profile)
+	case "daemon":
+		return daemonCommand(args, profile)
+	case "map":
+		return mapCommand(args, profile)
+	case "reset":
+		return resetCommand(args, profile)
+	case "status":
+		return statusCommand(args, profile)
+	case "list-devices":
+		return listDevicesCommand(args)
+	case "list-windows":
+		return listWindowsCommand(args)
+	case "list-outputs":
+		return listOutputsCommand(args)
+	case "list-regions":
+		return listRegionsCommand(args)
+	case "export":
+		return exportCommand(args, profile)
+	case "config":
+		return runConfigCommand(args, profile)
+	case "profiles":
+		return runProfilesCommand(args, profile)
+	}
+	if info, err := os.Stat(command); err == nil && !info.IsDir() {
+		log.Printf("WARN: passing just the config path is deprecated, use `apply %s`", command)
+		return applyCommand(append([]string{command}, args...), profile)
+	}
+	return usageErrorf("Unknown command '%s', run with -h for the list of commands", command)
+}
+
+// session is the config and the connected inputs a command works on.
+type session struct {
+	confPath        string
+	profileFlag     string
+	doc             tm_config.Document
+	profileName     string
+	explicitProfile bool
+	devices         tm_config.TabletMapperConfig
+	inputs          []tm_inputs.Input
+}
+
+// loadSession reads the effective config and the connected inputs. If strict
+// is set, a config which can't be read or has problems is an error,
+// otherwise the problems are logged and an unreadable config is replaced by
+// an empty one.
+func loadSession(confPath string, profileFlag string, strict bool) (*session, error) {
+	s, err := loadConfigSession(confPath, profileFlag, strict)
+	if err != nil {
+		return nil, err
+	}
+	if s.inputs, err = tm_inputs.GetInputs(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// loadConfigSession is like loadSession, but doesn't look for the connected
+// inputs.
+func loadConfigSession(confPath string, profileFlag string, strict bool) (*session, error) {
+	s := &session{confPath: confPath, profileFlag: profileFlag}
+	doc, origins, err := tm_config.ReadEffectiveDocument(confPath, profileFlag)
+	if err != nil {
+		if strict {
+			return nil, fmt.Errorf("Couldn't load config %w", err)
+		}
+		log.Printf("WARN: Couldn't load config %s", err.Error())
+		doc = tm_config.NewDocument()
+	}
+	if problems := tm_config.Validate(doc, origins); len(problems) > 0 {
+		for _, problem := range problems {
+			log.Printf("ERROR: %s", problem.Error())
+		}
+		if strict {
+			return nil, fmt.Errorf("Found %d problems in the config, run `config check` after fixing them", len(problems))
+		}
+	}
+	s.doc = doc
+	s.explicitProfile = isProfileExplicit(profileFlag)
+	var profile tm_config.Profile
+	if s.profileName, profile, err = selectProfile(doc, s.explicitProfile); err != nil {
+		return nil, err
+	}
+	s.devices = profile.Devices
+	log.Printf("INFO: using profile '%s'", s.profileName)
+	return s, nil
+}
+
+// commandFlags creates the flags of a command working on the config, which
+// all take --profile, defaulting to the global flag.
+func commandFlags(name string, profile string) (*flag.FlagSet, *string) {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	return flags, flags.String("profile", profile, "use this profile instead of the active one")
+}
+
+// dryRunFlag adds --dry-run to the flags of a command changing devices.
+func dryRunFlag(flags *flag.FlagSet) {
+	flags.BoolVar(&tm_inputs.DryRun, "dry-run", false, "print the xinput and xsetwacom commands instead of running them")
+}
+
+// stringList is a flag which can be given several times.
+type stringList []string
+
+func (list *stringList) String() string {
+	return strings.Join(*list, ", ")
+}
+
+func (list *stringList) Set(value string) error {
+	*list = append(*list, value)
+	return nil
+}
+
+func guiCommand(args []string, profile string) error {
+	flags, profileFlag := commandFlags("gui", profile)
+	args, err := parseInterspersed(flags, args)
+	if err != nil {
+		return err
+	}
+	confPath, err := configPathArgForUpdate(args)
+	if err != nil {
+		return err
+	}
+	s, err := loadSession(confPath, *profileFlag, false)
+	if err != nil {
+		return err
+	}
+	runGUI(s)
+	return nil
+}
+
+// applyCommand applies the profile once. It fails if any configured input
+// couldn't be mapped or no configured input is connected.
+func applyCommand(args []string, profile string) error {
+	flags, profileFlag := commandFlags("apply", profile)
+	dryRunFlag(flags)
+	args, err := parseInterspersed(flags, args)
+	if err != nil {
+		return err
+	}
+	confPath, err := configPathArg(args)
+	if err != nil {
+		return err
+	}
+	s, err := loadSession(confPath, *profileFlag, true)
+	if err != nil {
+		return err
+	}
+	_, err = applyDevices(s.inputs, s.devices)
+	return err
+}
+
+func daemonCommand(args []string, profile string) error {
+	flags, profileFlag := commandFlags("daemon", profile)
+	dryRunFlag(flags)
+	args, err := parseInterspersed(flags, args)
+	if err != nil {
+		return err
+	}
+	confPath, err := configPathArg(args)
+	if err != nil {
+		return err
+	}
+	s, err := loadSession(confPath, *profileFlag, true)
+	if err != nil {
+		return err
+	}
+	appliedAreas, err := applyDevices(s.inputs, s.devices)
+	if err != nil {
+		log.Printf("WARN: %s", err.Error())
+	}
+	d := newDaemon(s.inputs, s.doc, s.profileName, confPath, *profileFlag, !s.explicitProfile, appliedAreas)
+	return d.run()
+}
+
+// selectInputs returns the inputs named by the --device flags, by their full
+// name or a part of it matching only one input. Without flags it returns
+// the inputs the profile maps to an area, or all styluses if there are
+// none.
+func selectInputs(s *session, names []string) ([]*tm_inputs.Input, error) {
+	selected := make([]*tm_inputs.Input, 0)
+	if len(names) > 0 {
+		connected := tm_config.TabletMapperConfig{}
+		for _, input := range s.inputs {
+			connected[input.Name] = tm_inputs.InputConfig{}
+		}
+		for _, name := range names {
+			device, ok, err := tm_config.ResolveDevice(connected, name)
+			if err != nil {
+				return nil, usageError{err}
+			}
+			if !ok {
+				return nil, fmt.Errorf("No connected device matching '%s'", name)
+			}
+			for i := range s.inputs {
+				if s.inputs[i].Name == device {
+					selected = append(selected, &s.inputs[i])
+				}
+			}
+		}
+		return selected, nil
+	}
+	for i := range s.inputs {
+		if config, ok := s.devices[s.inputs[i].Name]; ok && (config.MappingType != "" || len(config.Rules) > 0) {
+			selected = append(selected, &s.inputs[i])
+		}
+	}
+	if len(selected) == 0 {
+		for i := range s.inputs {
+			if strings.Contains(s.inputs[i].Name, " stylus") {
+				selected = append(selected, &s.inputs[i])
+			}
+		}
+	}
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("No tablet connected")
+	}
+	return selected, nil
+}
+
+// mapCommand maps inputs to a window, an output or a region once, without
+// changing the config.
+func mapCommand(args []string, profile string) error {
+	flags, profileFlag := commandFlags("map", profile)
+	dryRunFlag(flags)
+	window := flags.String("window", "", "map to the window with this name")
+	output := flags.String("output", "", "map to this output, e.g. HDMI-1")
+	region := flags.String("region", "", "map to this part of the screen, e.g. 800x600+0+0, HDMI-1:left-half or primary:3x2:1")
+	active := flags.Bool("active", false, "map to the focused window")
+	rotation := flags.Int("rotation", 0, "rotation of the tablet: 0, 90, 180 or 270")
+	clip := flags.String("clip", "", "clip window areas to the visible screen or the largest monitor: screen or monitor")
+	var devices stringList
+	flags.Var(&devices, "device", "device to map, can be repeated; defaults to the devices the profile maps")
+	args, err := parseInterspersed(flags, args)
+	if err != nil {
+		return err
+	}
+	confPath, err := configPathArg(args)
+	if err != nil {
+		return err
+	}
+
+	targets := 0
+	for _, set := range []bool{*window != "", *output != "", *region != "", *active} {
+		if set {
+			targets++
+		}
+	}
+	if targets != 1 {
+		return usageErrorf("Expected exactly one of --window, --output, --region or --active")
+	}
+	if !slices.Contains(rotateOptions, *rotation) {
+		return usageErrorf("Invalid rotation %d, expected 0, 90, 180 or 270", *rotation)
+	}
+	if !slices.Contains(clipOptions, tm_inputs.InputClipMode(*clip)) {
+		return usageErrorf("Invalid clip mode '%s', expected screen or monitor", *clip)
+	}
+
+	target := tm_inputs.MappingTarget{Rotation: *rotation, Clip: tm_inputs.InputClipMode(*clip)}
+	focusedId := ""
+	switch {
+	case *window != "":
+		target.MappingType, target.WindowName = tm_inputs.INPUT_MAPPING_WINDOW, *window
+	case *active:
+		target.MappingType = tm_inputs.INPUT_MAPPING_WINDOW
+		if focusedId, err = windows.GetActiveWindowId(); err != nil {
+			return err
+		}
+	case *output != "":
+		target.MappingType, target.OutputName = tm_inputs.INPUT_MAPPING_OUTPUT, *output
+	case *region != "":
+		if _, err := outputs.ParseRegion(*region); err != nil {
+			return usageError{err}
+		}
+		target.MappingType, target.Region = tm_inputs.INPUT_MAPPING_REGION, *region
+	}
+
+	s, err := loadSession(confPath, *profileFlag, false)
+	if err != nil {
+		return err
+	}
+	inputs, err := selectInputs(s, devices)
+	if err != nil {
+		return err
+	}
+	state := readScreenState(focusedId)
+	coordMatrix, err := resolveTarget(target, state)
+	if err != nil {
+		return err
+	}
+	failed := 0
+	for _, input := range inputs {
+		if err := input.MapToArea(coordMatrix); err != nil {
+			log.Printf("ERROR: %s", err.Error())
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("Couldn't map %d of %d inputs", failed, len(inputs))
+	}
+	return nil
+}
+
+// resetCommand maps inputs back to the whole desktop and restores the
+// default action of the buttons the profile maps.
+func resetCommand(args []string, profile string) error {
+	flags, profileFlag := commandFlags("reset", profile)
+	dryRunFlag(flags)
+	var devices stringList
+	flags.Var(&devices, "device", "device to reset, can be repeated; defaults to all connected devices")
+	args, err := parseInterspersed(flags, args)
+	if err != nil {
+		return err
+	}
+	confPath, err := configPathArg(args)
+	if err != nil {
+		return err
+	}
+	s, err := loadSession(confPath, *profileFlag, false)
+	if err != nil {
+		return err
+	}
+	inputs := make([]*tm_inputs.Input, 0, len(s.inputs))
+	if len(devices) > 0 {
+		if inputs, err = selectInputs(s, devices); err != nil {
+			return err
+		}
+	} else {
+		for i := range s.inputs {
+			inputs = append(inputs, &s.inputs[i])
+		}
+	}
+	failed := 0
+	for _, input := range inputs {
+		// Pads have no area to reset.
+		if _, err := input.GetArea(); err == nil {
+			if err := input.MapToArea(tm_inputs.GetCoordinateMatrix(0)); err != nil {
+				log.Printf("ERROR: %s", err.Error())
+				failed++
+			}
+		}
+		config := s.devices[input.Name]
+		defaults := tm_inputs.Input{Id: input.Id, Name: input.Name, Config: tm_inputs.InputConfig{Buttons: map[string]string{}}}
+		for button := range config.Buttons {
+			defaults.Config.Buttons[button] = button
+		}
+		for _, buttons := range config.AppButtons {
+			for button := range buttons {
+				defaults.Config.Buttons[button] = button
+			}
+		}
+		if err := defaults.MapButtons(); err != nil {
+			log.Printf("ERROR: couldn't reset buttons of '%s'. %s", input.Name, err.Error())
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("Couldn't reset %d inputs", failed)
+	}
+	return nil
+}
+
+// describeTarget describes where a target maps to, e.g. "window 'Krita'".
+func describeTarget(target tm_inputs.MappingTarget) string {
+	switch target.MappingType {
+	case tm_inputs.INPUT_MAPPING_WINDOW:
+		if target.WindowName == "" {
+			return "focused window"
+		}
+		return fmt.Sprintf("window '%s'", target.WindowName)
+	case tm_inputs.INPUT_MAPPING_OUTPUT:
+		if target.OutputName == "" {
+			return "primary output"
+		}
+		return fmt.Sprintf("output %s", target.OutputName)
+	case tm_inputs.INPUT_MAPPING_REGION:
+		return fmt.Sprintf("region %s", target.Region)
+	case tm_inputs.INPUT_MAPPING_DESKTOP:
+		return "whole desktop"
+	case tm_inputs.INPUT_MAPPING_COORD_MATRIX:
+		return "fixed area"
+	}
+	return "not mapped"
+}
+
+// deviceStatus is a connected input as printed by `status --output json`.
+// Config is null for inputs the profile doesn't configure and Area is null
+// when the current mapping can't be read.
+type deviceStatus struct {
+	tm_inputs.Input
+	Config *tm_inputs.InputConfig        `json:"config"`
+	Area   *tm_inputs.CoordinationMatrix `json:"area"`
+}
+
+type status struct {
+	Profile    string         `json:"profile"`
+	ConfigPath string         `json:"configPath"`
+	Devices    []deviceStatus `json:"devices"`
+}
+
+// formatMatrix formats the rows of m separated by commas, e.g.
+// "0.5 0 0, 0 1 0, 0 0 1".
+func formatMatrix(m tm_inputs.CoordinationMatrix) string {
+	rows := make([]string, 0, len(m))
+	for _, row := range m {
+		rows = append(rows, fmt.Sprintf("%g %g %g", row[0], row[1], row[2]))
+	}
+	return strings.Join(rows, ", ")
+}
+
+// statusCommand prints the profile in use and, for every connected input,
+// its configured target and the area it is currently mapped to. It fails if
+// no tablet is connected.
+func statusCommand(args []string, profile string) error {
+	flags, profileFlag := commandFlags("status", profile)
+	format := outputFlag(flags)
+	args, err := parseInterspersed(flags, args)
+	if err != nil {
+		return err
+	}
+	confPath, err := configPathArg(args)
+	if err != nil {
+		return err
+	}
+	s, err := loadSession(confPath, *profileFlag, false)
+	if err != nil {
+		return err
+	}
+	result := status{Profile: s.profileName, ConfigPath: s.confPath, Devices: make([]deviceStatus, 0, len(s.inputs))}
+	for _, input := range s.inputs {
+		device := deviceStatus{Input: input}
+		if config, ok := s.devices[input.Name]; ok {
+			device.Config = &config
+		}
+		if area, err := input.GetArea(); err == nil {
+			device.Area = &area
+		}
+		result.Devices = append(result.Devices, device)
+	}
+
+	if *format == OUTPUT_JSON {
+		err = printJSON(result)
+	} else {
+		fmt.Printf("Profile: %s (%s)\n", result.Profile, result.ConfigPath)
+		rows := make([][]string, 0, len(result.Devices))
+		for _, device := range result.Devices {
+			configured := "no"
+			if device.Config != nil {
+				configured = describeTarget(device.Config.Target())
+				if len(device.Config.Rules) > 0 {
+					configured += fmt.Sprintf(", %d rules", len(device.Config.Rules))
+				}
+				configured += fmt.Sprintf(", %d buttons", len(device.Config.Buttons))
+			}
+			area := ""
+			if device.Area != nil {
+				area = formatMatrix(*device.Area)
+				if *device.Area == tm_inputs.GetCoordinateMatrix(0) {
+					area = "whole desktop"
+				}
+			}
+			rows = append(rows, []string{fmt.Sprint(device.Id), device.Name, configured, area})
+		}
+		err = printTable([]string{"ID", "NAME", "CONFIGURED", "AREA"}, rows)
+	}
+	if err != nil {
+		return err
+	}
+	if len(s.inputs) == 0 {
+		return fmt.Errorf("No tablet connected")
+	}
+	return nil
+}
+
+// listFlags parses the arguments of the list commands, which only take
+// --output.
+func listFlags(name string, args []string) (OutputFormat, error) {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	format := outputFlag(flags)
+	args, err := parseInterspersed(flags, args)
+	if err != nil {
+		return "", err
+	}
+	if len(args) > 0 {
+		return "", usageErrorf("Unexpected arguments %v", args)
+	}
+	return *format, nil
+}
+
+func listDevicesCommand(args []string) error {
+	format, err := listFlags("list-devices", args)
+	if err != nil {
+		return err
+	}
+	inputs, err := tm_inputs.GetInputs()
+	if err != nil {
+		return err
+	}
+	if format == OUTPUT_JSON {
+		return printJSON(inputs)
+	}
+	rows := make([][]string, 0, len(inputs))
+	for _, input := range inputs {
+		rows = append(rows, []string{fmt.Sprint(input.Id), input.Name})
+	}
+	return printTable([]string{"ID", "NAME"}, rows)
+}
+
+func listWindowsCommand(args []string) error {
+	format, err := listFlags("list-windows", args)
+	if err != nil {
+		return err
+	}
+	windowList, err := windows.ReadWindowList()
+	if err != nil {
+		return err
+	}
+	if format == OUTPUT_JSON {
+		return printJSON(windowList)
+	}
+	rows := make([][]string, 0, len(windowList))
+	for _, window := range windowList {
+		rows = append(rows, []string{window.Id, fmt.Sprint(window.DesktopId), window.Rect().Geometry(), window.Class, window.Title})
+	}
+	return printTable([]string{"ID", "DESKTOP", "GEOMETRY", "CLASS", "TITLE"}, rows)
+}
+
+func listOutputsCommand(args []string) error {
+	format, err := listFlags("list-outputs", args)
+	if err != nil {
+		return err
+	}
+	layout, err := outputs.GetLayout()
+	if err != nil {
+		return err
+	}
+	if format == OUTPUT_JSON {
+		return printJSON(layout.Outputs)
+	}
+	rows := make([][]string, 0, len(layout.Outputs))
+	for _, output := range layout.Outputs {
+		primary := "no"
+		if output.Primary {
+			primary = "yes"
+		}
+		rows = append(rows, []string{output.Name, output.Rect.Geometry(), primary, output.EDID})
+	}
+	return printTable([]string{"NAME", "GEOMETRY", "PRIMARY", "EDID"}, rows)
+}
+
+// regionListing is a region preset as printed by `list-regions --output json`.
+type regionListing struct {
+	Region string       `json:"region"`
+	Output string       `json:"output"`
+	Preset string       `json:"preset"`
+	Rect   outputs.Rect `json:"rect"`
+}
+
+// listRegionsCommand prints the region presets on the whole screen and on
+// every output, with the part of the screen they cover.
+func listRegionsCommand(args []string) error {
+	format, err := listFlags("list-regions", args)
+	if err != nil {
+		return err
+	}
+	layout, err := outputs.GetLayout()
+	if err != nil {
+		return err
+	}
+	names := []string{""}
+	for _, output := range layout.Outputs {
+		names = append(names, output.Name)
+	}
+	regions := make([]regionListing, 0, len(names)*len(outputs.RegionPresets))
+	for _, name := range names {
+		for _, preset := range outputs.RegionPresets {
+			region := preset.Region
+			region.Output = name
+			rect, err := layout.RegionRect(region)
+			if err != nil {
+				return err
+			}
+			regions = append(regions, regionListing{Region: outputs.RegionSpec(name, preset.Name), Output: name, Preset: preset.Name, Rect: rect})
+		}
+	}
+	if format == OUTPUT_JSON {
+		return printJSON(regions)
+	}
+	rows := make([][]string, 0, len(regions))
+	for _, region := range regions {
+		rows = append(rows, []string{region.Region, region.Rect.Geometry()})
+	}
+	return printTable([]string{"REGION", "GEOMETRY"}, rows)
+}
@@ -0,0 +1,663 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	tm_config "tablet_mapper/config"
+	tm_inputs "tablet_mapper/inputs"
+	"tablet_mapper/outputs"
+	"time"
+)
+
+func printUsage() {
+	name := os.Args[0]
+	fmt.Fprintf(flag.CommandLine.Output(), `Usage: %[1]s [--profile <name>] <command> [flags] [arguments]
+
+Commands:
+ gui [config-file-path]                open the GUI, the default without a command
+ apply [--dry-run] [config-file-path]  apply the profile and exit
+ daemon [--dry-run] [config-file-path] apply the profile and keep following focus, monitor and config changes
+ map --window <name>|--output <name>|--region <region>|--active
+     [--rotation <degrees>] [--clip screen|monitor] [--device <name>]... [--dry-run] [config-file-path]
+                                       map devices once without changing the config
+ reset [--device <name>]... [--dry-run] [config-file-path]
+                                       map devices to the whole desktop and restore their buttons
+ status [--output table|json] [config-file-path]
+                                       print the profile and the mapping of every device
+ list-devices [--output table|json]    print the connected tablet devices
+ list-windows [--output table|json]    print the open windows
+ list-outputs [--output table|json]    print the active outputs
+ list-regions [--output table|json]    print the region presets on the screen and every output
+ export [--format sh|xorg|otd] [--units-per-mm <n>] [config-file-path]
+                                       print the profile as a shell script, xorg.conf snippet or
+                                       OpenTabletDriver settings
+ config migrate [--dry-run] [config-file-path]
+ config restore [--backup <n>] [--list] [config-file-path]
+ config show [--effective] [--origin] [config-file-path]
+ config check [--effective] [config-file-path]
+ config convert [--to json|yaml|toml] <config-file-path> [destination-path]
+ config get [--effective] <device>[.<path>] [config-file-path]
+ config set [--create] <device>.<path> <value> [config-file-path]
+ config unset <device>[.<path>] [config-file-path]
+ profiles list [config-file-path]
+ profiles apply [--dry-run] <name> [config-file-path]
+ profiles save [--bind-layout] <name> [config-file-path]
+ profiles capture [--bind-layout] [--dry-run] <name> [config-file-path]
+                                       save the current settings of the devices as a profile
+ profiles import [--format sh|otd] [--units-per-mm <n>] [--dry-run] <name> <path> [config-file-path]
+                                       convert an xsetwacom/xinput shell script or OpenTabletDriver
+                                       settings into a profile
+ profiles layout
+ profiles delete <name> [config-file-path]
+
+gui, apply, daemon, map, reset, status and export also take --profile after
+the command. Commands exit with %[2]d on success, %[3]d on failure and %[4]d on
+invalid arguments.
+
+Flags:
+`, name, EXIT_OK, EXIT_FAILURE, EXIT_USAGE)
+	flag.PrintDefaults()
+}
+
+// runConfigCommand runs the `config` subcommands. They only work on the
+// config file, so no window is opened for them.
+func runConfigCommand(args []string, profile string) error {
+	if len(args) == 0 {
+		return usageErrorf("Missing config subcommand, expected one of: migrate, restore, show, check, convert, get, set, unset")
+	}
+	switch args[0] {
+	case "migrate":
+		return configMigrate(args[1:])
+	case "restore":
+		return configRestore(args[1:])
+	case "show":
+		return configShow(args[1:], profile)
+	case "check":
+		return configCheck(args[1:], profile)
+	case "convert":
+		return configConvert(args[1:])
+	case "get":
+		return configGet(args[1:], profile)
+	case "set", "unset":
+		return configEdit(args[0], args[1:], profile)
+	}
+	return usageErrorf("Unknown config subcommand '%s'", args[0])
+}
+
+// configMigrate upgrades the config file to the current version.
+func configMigrate(args []string) error {
+	flags := flag.NewFlagSet("config migrate", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "print the upgraded document instead of writing it")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	pathArg := configPathArgForUpdate
+	if *dryRun {
+		pathArg = configPathArg
+	}
+	confPath, err := pathArg(flags.Args())
+	if err != nil {
+		return err
+	}
+	doc, err := tm_config.ReadDocument(confPath)
+	if err != nil {
+		return err
+	}
+	if *dryRun {
+		previous, _ := os.ReadFile(confPath)
+		buf, err := tm_config.EncodeDocument(doc, tm_config.FormatOf(confPath), previous)
+		if err != nil {
+			return err
+		}
+		fmt.Print(string(buf))
+		return nil
+	}
+	return tm_config.WriteDocument(confPath, doc)
+}
+
+// configRestore replaces the config file with one of its backups.
+func configRestore(args []string) error {
+	flags := flag.NewFlagSet("config restore", flag.ContinueOnError)
+	number := flags.Int("backup", 1, "number of the backup to restore, 1 being the newest")
+	list := flags.Bool("list", false, "list the backups instead of restoring one")
+	args, err := parseInterspersed(flags, args)
+	if err != nil {
+		return err
+	}
+	confPath, err := configPathArg(args)
+	if err != nil {
+		return err
+	}
+	if *list {
+		for _, backup := range tm_config.ListBackups(confPath) {
+			fmt.Printf("%d %s %s\n", backup.Number, backup.ModTime.Format(time.DateTime), backup.Path)
+		}
+		return nil
+	}
+	return tm_config.RestoreBackup(confPath, *number)
+}
+
+// configShow prints the user config, or the config merged from all layers
+// with --effective. --origin prints every value with the layer it came from.
+func configShow(args []string, profile string) error {
+	flags := flag.NewFlagSet("config show", flag.ContinueOnError)
+	effective := flags.Bool("effective", false, "merge the system config, user config, environment and flags")
+	origin := flags.Bool("origin", false, "print every value with the layer it came from")
+	args, err := parseInterspersed(flags, args)
+	if err != nil {
+		return err
+	}
+	confPath, err := configPathArg(args)
+	if err != nil {
+		return err
+	}
+	doc, origins, err := readDocumentWithOrigins(confPath, profile, *effective)
+	if err != nil {
+		return err
+	}
+	if !*origin {
+		buf, err := tm_config.MarshalDocument(doc)
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(buf))
+		return nil
+	}
+	leaves, err := tm_config.Leaves(doc)
+	if err != nil {
+		return err
+	}
+	paths := make([]string, 0, len(leaves))
+	for path := range leaves {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		value, _ := json.Marshal(leaves[path])
+		layer := "default"
+		if origin, ok := origins[path]; ok {
+			layer = origin.String()
+		}
+		fmt.Printf("%s = %s\t# %s\n", path, value, layer)
+	}
+	return nil
+}
+
+// configConvert writes the config in the format of the destination's file
+// extension, or prints it in the format given with --to.
+func configConvert(args []string) error {
+	flags := flag.NewFlagSet("config convert", flag.ContinueOnError)
+	to := flags.String("to", "", "format to print the config in: json, yaml or toml")
+	args, err := parseInterspersed(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 || len(args) > 2 {
+		return usageErrorf("Expected the config file path and optionally a destination path")
+	}
+	var format tm_config.Format
+	if *to != "" {
+		if format, err = tm_config.ParseFormat(*to); err != nil {
+			return err
+		}
+	}
+	doc, err := tm_config.ReadDocument(args[0])
+	if err != nil {
+		return err
+	}
+	if len(args) == 2 {
+		if format != "" && format != tm_config.FormatOf(args[1]) {
+			return usageErrorf("Destination '%s' isn't a %s file", args[1], format)
+		}
+		return tm_config.WriteDocument(args[1], doc)
+	}
+	if format == "" {
+		return usageErrorf("Missing --to or a destination path")
+	}
+	buf, err := tm_config.EncodeDocument(doc, format, nil)
+	if err != nil {
+		return err
+	}
+	fmt.Print(string(buf))
+	return nil
+}
+
+// devicePathArg splits a path like "HUION Pad pad".buttons.1 into the device
+// in devices it refers to, see ResolveDevice, and the path below it. If
+// create is set, the device name is taken as it is, so a device missing from
+// devices can be added.
+func devicePathArg(devices tm_config.TabletMapperConfig, arg string, create bool) (string, []string, error) {
+	path, err := tm_config.ParsePath(arg)
+	if err != nil {
+		return "", nil, err
+	}
+	if create {
+		return path[0], path[1:], nil
+	}
+	device, ok, err := tm_config.ResolveDevice(devices, path[0])
+	if err != nil {
+		return "", nil, err
+	}
+	if !ok {
+		return "", nil, fmt.Errorf("No device matching '%s', use --create to add a device of that name", path[0])
+	}
+	return device, path[1:], nil
+}
+
+// configGet prints a value of a device config of the active profile.
+// Strings are printed as is, other values as JSON.
+func configGet(args []string, profile string) error {
+	flags := flag.NewFlagSet("config get", flag.ContinueOnError)
+	effective := flags.Bool("effective", false, "read the config merged from all layers")
+	args, err := parseInterspersed(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return usageErrorf("Missing the path of the value to get")
+	}
+	confPath, err := configPathArg(args[1:])
+	if err != nil {
+		return err
+	}
+	doc, _, err := readDocumentWithOrigins(confPath, profile, *effective)
+	if err != nil {
+		return err
+	}
+	_, p, err := doc.GetProfile(profile)
+	if err != nil {
+		return err
+	}
+	device, path, err := devicePathArg(p.Devices, args[0], false)
+	if err != nil {
+		return err
+	}
+	value, err := tm_config.GetValue(p.Devices[device], path)
+	if err != nil {
+		return err
+	}
+	if text, ok := value.(string); ok {
+		fmt.Println(text)
+		return nil
+	}
+	buf, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(buf))
+	return nil
+}
+
+// configEdit sets or unsets a value of a device config of the active
+// profile in the user config. Unsetting a whole device removes it. The
+// config isn't written if the change makes the device config invalid. The
+// whole document is encoded again, so only YAML and TOML comments are kept
+// from the previous formatting, see EncodeDocument.
+func configEdit(command string, args []string, profile string) error {
+	flags := flag.NewFlagSet("config "+command, flag.ContinueOnError)
+	create := false
+	values := 0
+	if command == "set" {
+		flags.BoolVar(&create, "create", false, "add the device if it isn't configured yet, taking its name as it is")
+		values = 1
+	}
+	args, err := parseInterspersed(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(args) < 1+values {
+		return usageErrorf("Expected the path of the value to %s", command)
+	}
+	confPath, err := configPathArgForUpdate(args[1+values:])
+	if err != nil {
+		return err
+	}
+	doc, err := tm_config.ReadDocumentForUpdate(confPath)
+	if err != nil {
+		return err
+	}
+	profileName, p, err := doc.GetProfile(profile)
+	if err != nil {
+		return err
+	}
+	device, path, err := devicePathArg(p.Devices, args[0], create)
+	if err != nil {
+		return err
+	}
+	if command == "unset" && len(path) == 0 {
+		delete(p.Devices, device)
+		return tm_config.WriteDocument(confPath, doc)
+	}
+	config := p.Devices[device]
+	if command == "set" {
+		config, err = tm_config.SetValue(config, path, args[1])
+	} else {
+		config, err = tm_config.UnsetValue(config, path)
+	}
+	if err != nil {
+		return err
+	}
+	p.Devices[device] = config
+
+	devicePath := tm_config.FormatPath([]string{"profiles", profileName, "devices", device})
+	problems := 0
+	for _, problem := range tm_config.Validate(doc, nil) {
+		if problem.Path == devicePath || strings.HasPrefix(problem.Path, devicePath+".") {
+			fmt.Fprintln(os.Stderr, problem.Error())
+			problems++
+		}
+	}
+	if problems > 0 {
+		return fmt.Errorf("Not saving the change, it leaves %d problems in the config of '%s'", problems, device)
+	}
+	return tm_config.WriteDocument(confPath, doc)
+}
+
+// readDocumentWithOrigins reads the user config, or the config merged from
+// all layers if effective is set.
+func readDocumentWithOrigins(confPath string, profile string, effective bool) (tm_config.Document, tm_config.Origins, error) {
+	if effective {
+		return tm_config.ReadEffectiveDocument(confPath, profile)
+	}
+	layer, err := tm_config.FileLayer(confPath)
+	if err != nil {
+		return tm_config.Document{}, nil, err
+	}
+	return tm_config.MergeLayers([]tm_config.Layer{layer})
+}
+
+// configCheck prints every problem found in the config and fails if there
+// are any.
+func configCheck(args []string, profile string) error {
+	flags := flag.NewFlagSet("config check", flag.ContinueOnError)
+	effective := flags.Bool("effective", false, "check the config merged from all layers")
+	args, err := parseInterspersed(flags, args)
+	if err != nil {
+		return err
+	}
+	confPath, err := configPathArg(args)
+	if err != nil {
+		return err
+	}
+	doc, origins, err := readDocumentWithOrigins(confPath, profile, *effective)
+	if err != nil {
+		return err
+	}
+	problems := tm_config.Validate(doc, origins)
+	for _, problem := range problems {
+		fmt.Println(problem.Error())
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("Found %d problems in the config", len(problems))
+	}
+	return nil
+}
+
+// runProfilesCommand runs the `profiles` subcommands. profile is the value
+// of the --profile flag.
+func runProfilesCommand(args []string, profile string) error {
+	if len(args) == 0 {
+		return usageErrorf("Missing profiles subcommand, expected one of: list, apply, save, capture, import, delete")
+	}
+	command, args := args[0], args[1:]
+	if command == "import" {
+		return profilesImport(args)
+	}
+	if command == "layout" {
+		layout, err := outputs.GetLayout()
+		if err != nil {
+			return err
+		}
+		buf, err := json.MarshalIndent(tm_config.MonitorLayoutOf(layout), "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(buf))
+		return nil
+	}
+	if command == "list" {
+		confPath, err := configPathArg(args)
+		if err != nil {
+			return err
+		}
+		doc, _, err := tm_config.ReadEffectiveDocument(confPath, profile)
+		if err != nil {
+			return err
+		}
+		for _, name := range doc.ProfileNames() {
+			marker := " "
+			if name == doc.ActiveProfile {
+				marker = "*"
+			}
+			layout := ""
+			if doc.Profiles[name].Layout != nil {
+				layout = fmt.Sprintf(", %d monitors", len(doc.Profiles[name].Layout.Outputs))
+			}
+			fmt.Printf("%s %s (%d devices%s)\n", marker, name, len(doc.Profiles[name].Devices), layout)
+		}
+		return nil
+	}
+
+	flags := flag.NewFlagSet("profiles "+command, flag.ContinueOnError)
+	bindLayout := flags.Bool("bind-layout", false, "save, capture: pick the profile automatically for the current monitor layout")
+	dryRunFlag(flags)
+	args, err := parseInterspersed(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return usageErrorf("Missing profile name for 'profiles %s'", command)
+	}
+	name := args[0]
+	confPath, err := configPathArgForUpdate(args[1:])
+	if err != nil {
+		return err
+	}
+	effective, _, err := tm_config.ReadEffectiveDocument(confPath, profile)
+	if err != nil {
+		return err
+	}
+	doc, err := tm_config.ReadDocumentForUpdate(confPath)
+	if err != nil {
+		return err
+	}
+	switch command {
+	case "apply":
+		_, p, err := effective.GetProfile(name)
+		if err != nil {
+			return err
+		}
+		inputs, err := tm_inputs.GetInputs()
+		if err != nil {
+			return err
+		}
+		if _, err := applyDevices(inputs, p.Devices); err != nil {
+			log.Printf("WARN: %s", err.Error())
+		}
+		if tm_inputs.DryRun {
+			return nil
+		}
+		doc.ActiveProfile = name
+	case "save":
+		_, p, err := effective.GetProfile("")
+		if err != nil {
+			return err
+		}
+		saved := tm_config.Profile{Devices: p.Devices, Layout: p.Layout}
+		if *bindLayout {
+			layout, err := outputs.GetLayout()
+			if err != nil {
+				return err
+			}
+			monitors := tm_config.MonitorLayoutOf(layout)
+			saved.Layout = &monitors
+		}
+		doc.Profiles[name] = tm_config.WithoutSystemDefaults(name, saved)
+	case "capture":
+		inputs, err := tm_inputs.GetInputs()
+		if err != nil {
+			return err
+		}
+		devices := captureDevices(inputs, readScreenState(""))
+		if len(devices) == 0 {
+			return fmt.Errorf("Couldn't read the settings of any device")
+		}
+		if tm_inputs.DryRun {
+			return printJSON(devices)
+		}
+		deviceNames := make([]string, 0, len(devices))
+		for device := range devices {
+			deviceNames = append(deviceNames, device)
+		}
+		sort.Strings(deviceNames)
+		for _, device := range deviceNames {
+			fmt.Println(captureSummary(device, devices[device]))
+		}
+		captured := tm_config.Profile{Devices: devices}
+		if *bindLayout {
+			layout, err := outputs.GetLayout()
+			if err != nil {
+				return err
+			}
+			monitors := tm_config.MonitorLayoutOf(layout)
+			captured.Layout = &monitors
+		}
+		doc.Profiles[name] = tm_config.WithoutSystemDefaults(name, captured)
+	case "delete":
+		if err := doc.DeleteProfile(name); err != nil {
+			return err
+		}
+	default:
+		return usageErrorf("Unknown profiles subcommand '%s'", command)
+	}
+	return tm_config.WriteDocument(confPath, doc)
+}
+
+// profilesImport converts an xsetwacom/xinput shell script or the settings
+// of OpenTabletDriver into a profile. What it can't translate is reported
+// on stderr.
+func profilesImport(args []string) error {
+	flags := flag.NewFlagSet("profiles import", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "print the imported devices instead of saving the profile")
+	format := flags.String("format", "", "format of the imported file: sh or otd, by default otd for .json files and sh otherwise")
+	unitsPerMM := flags.Float64("units-per-mm", 0, "otd: tablet units per millimeter, to convert the tablet area")
+	args, err := parseInterspersed(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(args) < 2 {
+		return usageErrorf("Expected a profile name and the path of the file to import")
+	}
+	name, importPath := args[0], args[1]
+	confPath, err := configPathArgForUpdate(args[2:])
+	if err != nil {
+		return err
+	}
+	if *format == "" {
+		*format = "sh"
+		if strings.HasSuffix(importPath, ".json") {
+			*format = "otd"
+		}
+	}
+	if *format != "sh" && *format != "otd" {
+		return usageErrorf("Unknown import format '%s', expected sh or otd", *format)
+	}
+	buf, err := os.ReadFile(importPath)
+	if err != nil {
+		return fmt.Errorf("Couldn't read %s: %w", importPath, err)
+	}
+	inputs, err := tm_inputs.GetInputs()
+	if err != nil {
+		log.Printf("WARN: Couldn't get the connected devices, keeping device names as they are: %s", err.Error())
+	}
+	var devices tm_config.TabletMapperConfig
+	var problems []tm_config.ValidationError
+	if *format == "otd" {
+		layout, err := outputs.GetLayout()
+		if err != nil {
+			log.Printf("WARN: %s, display areas are imported as regions", err.Error())
+		}
+		if devices, problems, err = tm_config.ImportOpenTabletDriver(importPath, buf, layout, inputs, *unitsPerMM); err != nil {
+			return err
+		}
+	} else {
+		devices, problems = tm_config.ImportScript(importPath, string(buf), inputs)
+	}
+	for _, problem := range problems {
+		fmt.Fprintln(os.Stderr, problem.Error())
+	}
+	if len(devices) == 0 {
+		return fmt.Errorf("Found no device settings in %s", importPath)
+	}
+	if len(problems) > 0 {
+		log.Printf("WARN: Couldn't translate %d settings of %s", len(problems), importPath)
+	}
+	if *dryRun {
+		return printJSON(devices)
+	}
+
+	doc, err := tm_config.ReadDocumentForUpdate(confPath)
+	if err != nil {
+		return err
+	}
+	doc.Profiles[name] = tm_config.Profile{Devices: devices}
+	profilePath := tm_config.FormatPath([]string{"profiles", name})
+	invalid := 0
+	for _, problem := range tm_config.Validate(doc, nil) {
+		if strings.HasPrefix(problem.Path, profilePath+".") {
+			fmt.Fprintln(os.Stderr, problem.Error())
+			invalid++
+		}
+	}
+	if invalid > 0 {
+		return fmt.Errorf("Not saving profile '%s', it has %d problems", name, invalid)
+	}
+	return tm_config.WriteDocument(confPath, doc)
+}
+
+// parseInterspersed parses flags given before or after the positional
+// arguments and returns the positional arguments.
+func parseInterspersed(flags *flag.FlagSet, args []string) ([]string, error) {
+	positional := make([]string, 0, len(args))
+	for {
+		if err := flags.Parse(args); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return nil, err
+			}
+			return nil, usageError{err}
+		}
+		args = flags.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// configPathArg returns the config path passed as the only positional
+// argument or the default path.
+func configPathArg(args []string) (string, error) {
+	if len(args) > 1 {
+		return "", usageErrorf("Unexpected arguments %v", args[1:])
+	}
+	if len(args) == 1 {
+		return args[0], nil
+	}
+	return tm_config.GetDefaultConfpath()
+}
+
+// configPathArgForUpdate is configPathArg for commands which save the
+// config. The legacy config file is moved to the default path first.
+func configPathArgForUpdate(args []string) (string, error) {
+	if len(args) != 0 || tm_inputs.DryRun {
+		return configPathArg(args)
+	}
+	return tm_config.GetDefaultConfpathForUpdate()
+}
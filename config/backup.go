@@ -0,0 +1,131 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// BACKUP_COUNT is the number of previous versions kept next to the config
+// file as <config>.1 (newest) to <config>.BACKUP_COUNT (oldest).
+const BACKUP_COUNT = 5
+
+type Backup struct {
+	Number  int
+	Path    string
+	ModTime time.Time
+}
+
+func backupPath(confPath string, number int) string {
+	return fmt.Sprintf("%s.%d", confPath, number)
+}
+
+// writeFileAtomic replaces the file at filePath with buf, so that a crash
+// leaves either the old or the new content. The replaced content is kept as
+// the newest backup. If filePath is a symlink, e.g. into a dotfiles
+// repository, the file it points to is replaced and the link is kept. The
+// file keeps its permissions.
+func writeFileAtomic(filePath string, buf []byte) error {
+	target := filePath
+	if resolved, err := filepath.EvalSymlinks(filePath); err == nil {
+		target = resolved
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	mode := fs.FileMode(0o644)
+	if info, err := os.Stat(target); err == nil {
+		mode = info.Mode().Perm()
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(filepath.Dir(target), filepath.Base(target)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	if _, err = file.Write(buf); err != nil {
+		file.Close()
+		return err
+	}
+	if err = file.Chmod(mode); err != nil {
+		file.Close()
+		return err
+	}
+	if err = file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+	if err = rotateBackups(filePath, target); err != nil {
+		log.Printf("WARN: couldn't back up config file %s. %s", filePath, err.Error())
+	}
+	if err = os.Rename(file.Name(), target); err != nil {
+		return err
+	}
+	if dir, err := os.Open(filepath.Dir(target)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return nil
+}
+
+// rotateBackups shifts the existing backups of confPath by one, dropping
+// the oldest, and keeps the current content of target, the file confPath
+// points to, as the newest backup.
+func rotateBackups(confPath string, target string) error {
+	if _, err := os.Stat(target); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	for number := BACKUP_COUNT - 1; number >= 1; number-- {
+		err := os.Rename(backupPath(confPath, number), backupPath(confPath, number+1))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	newest := backupPath(confPath, 1)
+	if err := os.Link(target, newest); err == nil {
+		return nil
+	}
+	buf, err := os.ReadFile(target)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(newest, buf, 0o644)
+}
+
+// ListBackups returns the existing backups of the config file, newest first.
+func ListBackups(confPath string) []Backup {
+	backups := make([]Backup, 0, BACKUP_COUNT)
+	for number := 1; number <= BACKUP_COUNT; number++ {
+		backup := Backup{Number: number, Path: backupPath(confPath, number)}
+		if info, err := os.Stat(backup.Path); err == nil {
+			backup.ModTime = info.ModTime()
+			backups = append(backups, backup)
+		}
+	}
+	return backups
+}
+
+// RestoreBackup replaces the config file with the given backup. The replaced
+// config becomes the newest backup, so restoring can be undone.
+func RestoreBackup(confPath string, number int) error {
+	if number < 1 || number > BACKUP_COUNT {
+		return fmt.Errorf("Backup number must be between 1 and %d", BACKUP_COUNT)
+	}
+	buf, err := os.ReadFile(backupPath(confPath, number))
+	if err != nil {
+		return fmt.Errorf("Couldn't read backup %d of %s. %w", number, confPath, err)
+	}
+	if _, _, err = parseFile(confPath, buf); err != nil {
+		return fmt.Errorf("Backup %d of %s isn't a valid config. %w", number, confPath, err)
+	}
+	log.Printf("INFO: restoring %s from backup %d", confPath, number)
+	return writeFileAtomic(confPath, buf)
+}
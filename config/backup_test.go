@@ -0,0 +1,88 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFileAtomicKeepsSymlinkAndMode(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "dotfiles", "config.json")
+	link := filepath.Join(dir, "config.json")
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(target, []byte("old"), 0o640); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(target, link); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writeFileAtomic(link, []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+
+	if info, err := os.Lstat(link); err != nil {
+		t.Error(err)
+	} else if info.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("%s is no longer a symlink", link)
+	}
+	if buf, _ := os.ReadFile(target); string(buf) != "new" {
+		t.Errorf("target has %q, want %q", buf, "new")
+	}
+	if info, err := os.Stat(target); err != nil {
+		t.Error(err)
+	} else if info.Mode().Perm() != 0o640 {
+		t.Errorf("target mode is %v, want %v", info.Mode().Perm(), os.FileMode(0o640))
+	}
+	if buf, _ := os.ReadFile(backupPath(link, 1)); string(buf) != "old" {
+		t.Errorf("backup has %q, want %q", buf, "old")
+	}
+}
+
+func TestWriteFileAtomicNewFile(t *testing.T) {
+	confPath := filepath.Join(t.TempDir(), "tablet-mapper", "config.json")
+	if err := writeFileAtomic(confPath, []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(confPath); err != nil {
+		t.Error(err)
+	} else if info.Mode().Perm() != 0o644 {
+		t.Errorf("new file mode is %v, want %v", info.Mode().Perm(), os.FileMode(0o644))
+	}
+	if backups := ListBackups(confPath); len(backups) != 0 {
+		t.Errorf("new file has backups %v", backups)
+	}
+}
+
+func TestMigrateLegacyConfig(t *testing.T) {
+	dir := t.TempDir()
+	legacyPath := filepath.Join(dir, legacyConfigFileName)
+	confPath := filepath.Join(dir, configDirName, configFileName)
+	if err := os.WriteFile(legacyPath, []byte("{}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := migrateLegacyConfig(legacyPath, confPath); err != nil {
+		t.Fatal(err)
+	}
+	if buf, _ := os.ReadFile(confPath); string(buf) != "{}" {
+		t.Errorf("config has %q, want %q", buf, "{}")
+	}
+	if exists(legacyPath) || !exists(legacyPath+".migrated") {
+		t.Errorf("%s wasn't renamed to %s.migrated", legacyPath, legacyPath)
+	}
+
+	// A config at the new path is never replaced.
+	if err := os.WriteFile(legacyPath, []byte("old"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := migrateLegacyConfig(legacyPath, confPath); err != nil {
+		t.Fatal(err)
+	}
+	if buf, _ := os.ReadFile(confPath); string(buf) != "{}" {
+		t.Errorf("config has %q after a second migration, want %q", buf, "{}")
+	}
+}
@@ -2,8 +2,10 @@ package config
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
 	"os"
 	"os/user"
@@ -11,58 +13,227 @@ import (
 	"tablet_mapper/inputs"
 )
 
-const configFileName = ".tablet-mapper.conf"
+const (
+	configDirName        = "tablet-mapper"
+	configFileName       = "config.json"
+	legacyConfigFileName = ".tablet-mapper.conf"
+)
+
+// configFileNames are the names of the config file in the config directory,
+// in the order they are looked for.
+var configFileNames = []string{configFileName, "config.yaml", "config.yml", "config.toml"}
 
 type TabletMapperConfig map[string]inputs.InputConfig
-func ReadConfigFromFile(confPath string) (TabletMapperConfig, error) {
+
+// Document is the top level of the config file.
+type Document struct {
+	Version       int                `json:"version"`
+	ActiveProfile string             `json:"activeProfile"`
+	Profiles      map[string]Profile `json:"profiles"`
+}
+
+func NewDocument() Document {
+	return Document{
+		Version:       CONFIG_VERSION,
+		ActiveProfile: DEFAULT_PROFILE,
+		Profiles:      map[string]Profile{DEFAULT_PROFILE: {Devices: TabletMapperConfig{}}},
+	}
+}
+
+// ReadConfigFromFile returns the device configs of the given profile, or of
+// the active profile if profile is empty.
+func ReadConfigFromFile(confPath string, profile string) (TabletMapperConfig, error) {
+	doc, err := ReadDocument(confPath)
+	if err != nil {
+		return nil, err
+	}
+	_, p, err := doc.GetProfile(profile)
+	if err != nil {
+		return nil, err
+	}
+	return p.Devices, nil
+}
+
+// ReadDocument reads the config file, migrating it to the current version
+// if it was written by an older version.
+func ReadDocument(confPath string) (Document, error) {
 	if file, err := os.Open(confPath); err != nil {
 		log.Printf("WARN: couldn't read from config file '%s'. %s", confPath, err.Error())
 	} else {
 		defer file.Close()
 		if buf, err := io.ReadAll(file); err != nil {
 			log.Printf("WARN: read config %s", err.Error())
+		} else if doc, version, err := parseFile(confPath, buf); err != nil {
+			log.Printf("ERROR: couldn't read config file '%s'. %s", confPath, err.Error())
 		} else {
-			var config TabletMapperConfig
-			if err = json.Unmarshal(buf, &config); err != nil {
-				log.Printf("ERROR: couldn't read config file '%s'. %s", confPath, err.Error())
-			} else {
-				//log.Printf("INFO: read config %v", config)
-				return config, nil
+			if version != CONFIG_VERSION {
+				log.Printf("INFO: migrated config file '%s' from version %d to %d", confPath, version, CONFIG_VERSION)
 			}
+			return doc, nil
+		}
+	}
+	return Document{}, fmt.Errorf("ERROR: Couldn't read config file '%s'", confPath)
+}
+
+// parseFile decodes the content of a config file in the format of confPath.
+func parseFile(confPath string, buf []byte) (Document, int, error) {
+	converted, _, err := decodeFile(confPath, FormatOf(confPath), buf)
+	if err != nil {
+		return Document{}, 0, err
+	}
+	return ParseDocument(converted)
+}
+
+// ParseDocument decodes a JSON config document of any version. It returns the
+// version the document was written with.
+func ParseDocument(buf []byte) (Document, int, error) {
+	upgraded, version, err := migrate(buf)
+	if err != nil {
+		return Document{}, version, err
+	}
+	var doc Document
+	if err = json.Unmarshal(upgraded, &doc); err != nil {
+		return Document{}, version, err
+	}
+	if doc.Profiles == nil {
+		doc.Profiles = map[string]Profile{}
+	}
+	for name, profile := range doc.Profiles {
+		if profile.Devices == nil {
+			profile.Devices = TabletMapperConfig{}
+			doc.Profiles[name] = profile
 		}
 	}
-	return nil, fmt.Errorf("ERROR: Couldn't read config file '%s'", confPath)
+	if doc.ActiveProfile == "" {
+		doc.ActiveProfile = DEFAULT_PROFILE
+	}
+	return doc, version, nil
+}
 
+func MarshalDocument(doc Document) ([]byte, error) {
+	doc.Version = CONFIG_VERSION
+	return json.MarshalIndent(doc, "", "  ")
 }
 
+// GetDefaultConfpath returns $TABLET_MAPPER_CONFIG if set, or else the
+// config file in $XDG_CONFIG_HOME/tablet-mapper, see configFileNames. While
+// only the legacy ~/.tablet-mapper.conf exists, that is read instead.
 func GetDefaultConfpath() (string, error) {
-	if user, err := user.Current(); err != nil {
-		log.Printf("ERROR: couldn't read current user %s ", err.Error())
-	} else {
-		confPath := path.Join(user.HomeDir, configFileName)
-		log.Printf("INFO: reading from file %s", confPath)
-		return confPath, nil
+	confPath, legacyPath, err := defaultConfpaths()
+	if err != nil {
+		return "", err
+	}
+	if legacyPath != "" && !exists(confPath) && exists(legacyPath) {
+		log.Printf("INFO: reading from legacy file %s", legacyPath)
+		return legacyPath, nil
 	}
-	return "", fmt.Errorf("ERROR: Couldn't get default config file %s", configFileName)
+	log.Printf("INFO: reading from file %s", confPath)
+	return confPath, nil
+}
 
+// GetDefaultConfpathForUpdate is GetDefaultConfpath for commands which write
+// the config. If only the legacy ~/.tablet-mapper.conf exists, it is moved
+// to $XDG_CONFIG_HOME/tablet-mapper first.
+func GetDefaultConfpathForUpdate() (string, error) {
+	confPath, legacyPath, err := defaultConfpaths()
+	if err != nil {
+		return "", err
+	}
+	if legacyPath != "" {
+		if err := migrateLegacyConfig(legacyPath, confPath); err != nil {
+			log.Printf("WARN: couldn't move config to %s, using %s. %s", confPath, legacyPath, err.Error())
+			return legacyPath, nil
+		}
+	}
+	log.Printf("INFO: reading from file %s", confPath)
+	return confPath, nil
 }
 
-func WriteConfig(config TabletMapperConfig) {
-	if user, err := user.Current(); err != nil {
+// defaultConfpaths returns the default config file and the legacy one,
+// which is empty when $TABLET_MAPPER_CONFIG is set.
+func defaultConfpaths() (string, string, error) {
+	if confPath := os.Getenv(ENV_CONFIG); confPath != "" {
+		log.Printf("INFO: using file %s set by $%s", confPath, ENV_CONFIG)
+		return confPath, "", nil
+	}
+	user, err := user.Current()
+	if err != nil {
 		log.Printf("ERROR: couldn't read current user %s ", err.Error())
-	} else {
-		confPath := path.Join(user.HomeDir, configFileName)
-		log.Printf("INFO: writing to file %s", confPath)
-		if file, err := os.Create(confPath); err != nil {
-			log.Printf("ERROR: couldn't write to config file %s. %s", confPath, err.Error())
-		} else {
-			defer file.Close()
-			buf, _ := json.MarshalIndent(config, "", "  ")
-			if _, err = file.Write(buf); err != nil {
-				log.Printf("ERROR: couldn't write to config file %s. %s", confPath, err.Error())
-			}
-			//log.Printf("INFO: wrote config: %s",buf )
-			file.Sync()
+		return "", "", fmt.Errorf("ERROR: Couldn't get default config file %s", configFileName)
+	}
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	if configHome == "" {
+		configHome = path.Join(user.HomeDir, ".config")
+	}
+	return findConfigFile(path.Join(configHome, configDirName)), path.Join(user.HomeDir, legacyConfigFileName), nil
+}
+
+func exists(confPath string) bool {
+	_, err := os.Stat(confPath)
+	return err == nil
+}
+
+// findConfigFile returns the first existing config file in dir, or the
+// path of config.json if there is none.
+func findConfigFile(dir string) string {
+	for _, name := range configFileNames {
+		if _, err := os.Stat(path.Join(dir, name)); err == nil {
+			return path.Join(dir, name)
 		}
 	}
+	return path.Join(dir, configFileName)
+}
+
+func migrateLegacyConfig(legacyPath string, confPath string) error {
+	if _, err := os.Stat(confPath); !errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	buf, err := os.ReadFile(legacyPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if err = writeFileAtomic(confPath, buf); err != nil {
+		return err
+	}
+	log.Printf("INFO: moved config file %s to %s", legacyPath, confPath)
+	return os.Rename(legacyPath, legacyPath+".migrated")
+}
+
+// WriteConfig stores config as the given profile in the config file at
+// confPath, keeping the other profiles. Devices configured the same way by
+// the system layers are left out, see WithoutSystemDefaults.
+func WriteConfig(confPath string, profile string, config TabletMapperConfig) error {
+	doc, err := ReadDocumentForUpdate(confPath)
+	if err != nil {
+		return err
+	}
+	p := doc.Profiles[profile]
+	p.Devices = config
+	doc.Profiles[profile] = WithoutSystemDefaults(profile, p)
+	return WriteDocument(confPath, doc)
+}
+
+// ReadDocumentForUpdate reads the config file to change and write it back.
+// A missing file results in a new document, while a file which can't be
+// read is an error so it doesn't get overwritten.
+func ReadDocumentForUpdate(confPath string) (Document, error) {
+	if _, err := os.Stat(confPath); errors.Is(err, fs.ErrNotExist) {
+		return NewDocument(), nil
+	}
+	return ReadDocument(confPath)
+}
+
+func WriteDocument(confPath string, doc Document) error {
+	log.Printf("INFO: writing to file %s", confPath)
+	previous, _ := os.ReadFile(confPath)
+	buf, err := EncodeDocument(doc, FormatOf(confPath), previous)
+	if err != nil {
+		return fmt.Errorf("Couldn't encode config %w", err)
+	}
+	if err = writeFileAtomic(confPath, buf); err != nil {
+		return fmt.Errorf("Couldn't write to config file %s. %w", confPath, err)
+	}
+	return nil
 }
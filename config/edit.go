@@ -0,0 +1,224 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"tablet_mapper/inputs"
+)
+
+var inputConfigType = reflect.TypeOf(inputs.InputConfig{})
+
+// ResolveDevice returns the device of devices that name refers to: the one
+// with exactly that name, or else the only one whose name contains name,
+// ignoring case. So "stylus" works for "HUION H420 Pen stylus". ok is false
+// if no device matches.
+func ResolveDevice(devices TabletMapperConfig, name string) (device string, ok bool, err error) {
+	if _, ok := devices[name]; ok {
+		return name, true, nil
+	}
+	matches := make([]string, 0)
+	for _, device := range sortedKeys(devices) {
+		if strings.Contains(strings.ToLower(device), strings.ToLower(name)) {
+			matches = append(matches, device)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", false, nil
+	case 1:
+		return matches[0], true, nil
+	}
+	return "", false, fmt.Errorf("'%s' matches several devices: %s", name, strings.Join(matches, ", "))
+}
+
+// toTree converts v to the maps, lists and values encoding/json decodes to.
+func toTree(v any) (any, error) {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var tree any
+	err = json.Unmarshal(buf, &tree)
+	return tree, err
+}
+
+func configFromTree(tree any) (inputs.InputConfig, error) {
+	buf, err := json.Marshal(tree)
+	if err != nil {
+		return inputs.InputConfig{}, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	dec.DisallowUnknownFields()
+	var config inputs.InputConfig
+	err = dec.Decode(&config)
+	return config, err
+}
+
+// GetValue returns the value at path in config as decoded by encoding/json,
+// e.g. a string for ["buttons", "1"] or a list for ["coordMatrix"].
+func GetValue(config inputs.InputConfig, path []string) (any, error) {
+	_, path, err := pathType(inputConfigType, path)
+	if err != nil {
+		return nil, err
+	}
+	value, err := toTree(config)
+	if err != nil {
+		return nil, err
+	}
+	for i, key := range path {
+		var ok bool
+		switch node := value.(type) {
+		case map[string]any:
+			value, ok = node[key]
+		case []any:
+			index, _ := strconv.Atoi(key)
+			if ok = index < len(node); ok {
+				value = node[index]
+			}
+		}
+		if !ok || value == nil {
+			return nil, fmt.Errorf("%s isn't set", FormatPath(path[:i+1]))
+		}
+	}
+	return value, nil
+}
+
+// SetValue returns config with the value at path set to text. Text is taken
+// as is for string fields and parsed as JSON for all others, so numbers,
+// lists and objects can be set. A list can be extended by setting the item
+// after its last one.
+func SetValue(config inputs.InputConfig, path []string, text string) (inputs.InputConfig, error) {
+	t, path, err := pathType(inputConfigType, path)
+	if err != nil {
+		return config, err
+	}
+	value := reflect.New(t)
+	if t.Kind() == reflect.String {
+		value.Elem().SetString(text)
+	} else if err = json.Unmarshal([]byte(text), value.Interface()); err != nil {
+		return config, fmt.Errorf("Invalid value '%s' for %s, expected %s", text, FormatPath(path), typeName(t))
+	}
+	newValue, err := toTree(value.Interface())
+	if err != nil {
+		return config, err
+	}
+	tree, err := toTree(config)
+	if err != nil {
+		return config, err
+	}
+	if tree, err = setIn(inputConfigType, tree, path, newValue); err != nil {
+		return config, err
+	}
+	return configFromTree(tree)
+}
+
+// UnsetValue returns config without the value at path, which resets fields
+// to their default, removes map entries and removes items from lists.
+func UnsetValue(config inputs.InputConfig, path []string) (inputs.InputConfig, error) {
+	if len(path) == 0 {
+		return config, fmt.Errorf("Missing path of the value to unset")
+	}
+	_, path, err := pathType(inputConfigType, path)
+	if err != nil {
+		return config, err
+	}
+	tree, err := toTree(config)
+	if err != nil {
+		return config, err
+	}
+	parent := tree
+	for _, key := range path[:len(path)-1] {
+		switch node := parent.(type) {
+		case map[string]any:
+			parent = node[key]
+		case []any:
+			index, _ := strconv.Atoi(key)
+			if index >= len(node) {
+				return config, nil
+			}
+			parent = node[index]
+		}
+	}
+	last := path[len(path)-1]
+	switch node := parent.(type) {
+	case map[string]any:
+		delete(node, last)
+	case []any:
+		t, _, _ := pathType(inputConfigType, path[:len(path)-1])
+		if t.Kind() == reflect.Array {
+			return config, fmt.Errorf("Can't remove items of %s, it has a fixed size", FormatPath(path[:len(path)-1]))
+		}
+		index, _ := strconv.Atoi(last)
+		if index < len(node) {
+			// The shortened list has to be stored in its parent.
+			if tree, err = setIn(inputConfigType, tree, path[:len(path)-1], append(node[:index], node[index+1:]...)); err != nil {
+				return config, err
+			}
+		}
+	}
+	return configFromTree(tree)
+}
+
+// setIn sets the value at path in node, which has type t, creating missing
+// objects and lists on the way.
+func setIn(t reflect.Type, node any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	childType, _, err := pathType(t, path[:1])
+	if err != nil {
+		return nil, err
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if node == nil {
+		if t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+			node = []any{}
+		} else {
+			node = map[string]any{}
+		}
+	}
+	switch container := node.(type) {
+	case map[string]any:
+		child, err := setIn(childType, container[path[0]], path[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		container[path[0]] = child
+		return container, nil
+	case []any:
+		index, _ := strconv.Atoi(path[0])
+		if index > len(container) {
+			return nil, fmt.Errorf("Can't set item %d of a list with %d items", index, len(container))
+		}
+		if index == len(container) {
+			container = append(container, nil)
+		}
+		child, err := setIn(childType, container[index], path[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		container[index] = child
+		return container, nil
+	}
+	return nil, fmt.Errorf("Can't set a field of %v", node)
+}
+
+func typeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return typeName(t.Elem())
+	case reflect.Int, reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Bool:
+		return "true or false"
+	case reflect.Slice, reflect.Array:
+		return "a JSON list"
+	}
+	return "a JSON object"
+}
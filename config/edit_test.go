@@ -0,0 +1,201 @@
+package config
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+	"tablet_mapper/inputs"
+	"testing"
+)
+
+func TestSetValue(t *testing.T) {
+	base := inputs.InputConfig{
+		MappingType: inputs.INPUT_MAPPING_DESKTOP,
+		Buttons:     map[string]string{"1": "key +ctrl +z -z -ctrl"},
+		Fallbacks:   []inputs.MappingTarget{{MappingType: inputs.INPUT_MAPPING_OUTPUT}},
+	}
+	tests := []struct {
+		path  string
+		value string
+		check func(config inputs.InputConfig) bool
+	}{
+		{"rotation", "90", func(c inputs.InputConfig) bool { return c.Rotation == 90 }},
+		{"windowName", "krita", func(c inputs.InputConfig) bool { return c.WindowName == "krita" }},
+		{"mappingType", "window", func(c inputs.InputConfig) bool { return c.MappingType == inputs.INPUT_MAPPING_WINDOW }},
+		{"buttons.2", "key h", func(c inputs.InputConfig) bool {
+			return c.Buttons["2"] == "key h" && c.Buttons["1"] == "key +ctrl +z -z -ctrl"
+		}},
+		{"fallbacks.0.rotation", "90", func(c inputs.InputConfig) bool {
+			return reflect.DeepEqual(c.Fallbacks, []inputs.MappingTarget{{MappingType: inputs.INPUT_MAPPING_OUTPUT, Rotation: 90}})
+		}},
+		{"fallbacks", `[{"mappingType": "desktop"}]`, func(c inputs.InputConfig) bool {
+			return reflect.DeepEqual(c.Fallbacks, []inputs.MappingTarget{{MappingType: inputs.INPUT_MAPPING_DESKTOP}})
+		}},
+		{"coordMatrix.1.1", "0.5", func(c inputs.InputConfig) bool { return c.CoordMatrix[1][1] == 0.5 }},
+		{"appButtons.krita.1", "key e", func(c inputs.InputConfig) bool { return c.AppButtons["krita"]["1"] == "key e" }},
+		{"rules.0", `{"match": {"class": "krita"}, "mappingType": "window"}`, func(c inputs.InputConfig) bool {
+			return len(c.Rules) == 1 && c.Rules[0].Match.Class == "krita"
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			path, err := ParsePath(test.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := SetValue(base, path, test.value)
+			if err != nil {
+				t.Fatalf("SetValue(%s, %q) failed: %v", test.path, test.value, err)
+			}
+			if !test.check(got) {
+				t.Errorf("SetValue(%s, %q) = %+v", test.path, test.value, got)
+			}
+		})
+	}
+}
+
+func TestSetValueErrors(t *testing.T) {
+	tests := []struct {
+		path  string
+		value string
+		want  string
+	}{
+		{"rotation", "ninety", "Invalid value 'ninety' for rotation, expected a number"},
+		{"colour", "red", "colour"},
+		{"fallbacks.5", "{}", "Can't set item 5 of a list with 1 items"},
+		{"coordMatrix.3.0", "1", "coordMatrix"},
+	}
+	base := inputs.InputConfig{Fallbacks: []inputs.MappingTarget{{MappingType: inputs.INPUT_MAPPING_DESKTOP}}}
+	for _, test := range tests {
+		path, err := ParsePath(test.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := SetValue(base, path, test.value); err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("SetValue(%s, %q) error = %v, want one containing %q", test.path, test.value, err, test.want)
+		}
+	}
+}
+
+func TestUnsetValue(t *testing.T) {
+	base := inputs.InputConfig{
+		MappingType: inputs.INPUT_MAPPING_WINDOW,
+		WindowName:  "krita",
+		Rotation:    90,
+		Buttons:     map[string]string{"1": "key a", "2": "key b"},
+		Rules: []inputs.MappingRule{
+			{Match: inputs.WindowMatch{Class: "krita"}},
+			{Match: inputs.WindowMatch{Class: "gimp"}},
+		},
+	}
+	tests := []struct {
+		path  string
+		check func(config inputs.InputConfig) bool
+	}{
+		{"rotation", func(c inputs.InputConfig) bool { return c.Rotation == 0 && c.WindowName == "krita" }},
+		{"windowName", func(c inputs.InputConfig) bool { return c.WindowName == "" }},
+		{"buttons.1", func(c inputs.InputConfig) bool { return reflect.DeepEqual(c.Buttons, map[string]string{"2": "key b"}) }},
+		{"buttons.9", func(c inputs.InputConfig) bool { return len(c.Buttons) == 2 }},
+		{"rules.0", func(c inputs.InputConfig) bool { return len(c.Rules) == 1 && c.Rules[0].Match.Class == "gimp" }},
+		{"rules.5", func(c inputs.InputConfig) bool { return len(c.Rules) == 2 }},
+	}
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			path, err := ParsePath(test.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := UnsetValue(base, path)
+			if err != nil {
+				t.Fatalf("UnsetValue(%s) failed: %v", test.path, err)
+			}
+			if !test.check(got) {
+				t.Errorf("UnsetValue(%s) = %+v", test.path, got)
+			}
+		})
+	}
+	if _, err := UnsetValue(base, []string{"coordMatrix", "0"}); err == nil {
+		t.Errorf("UnsetValue(coordMatrix.0) succeeded, want an error for the fixed size matrix")
+	}
+}
+
+func TestResolveDevice(t *testing.T) {
+	devices := TabletMapperConfig{
+		"HUION H420 Pen stylus": {},
+		"HUION Pad pad":         {},
+		"Pad":                   {},
+	}
+	tests := []struct {
+		name    string
+		want    string
+		wantOk  bool
+		wantErr bool
+	}{
+		{"HUION Pad pad", "HUION Pad pad", true, false},
+		{"stylus", "HUION H420 Pen stylus", true, false},
+		{"STYLUS", "HUION H420 Pen stylus", true, false},
+		{"Pad", "Pad", true, false},
+		{"pad", "", false, true},
+		{"eraser", "", false, false},
+	}
+	for _, test := range tests {
+		got, ok, err := ResolveDevice(devices, test.name)
+		if got != test.want || ok != test.wantOk || (err != nil) != test.wantErr {
+			t.Errorf("ResolveDevice(%q) = %q, %v, %v, want %q, %v, error %v", test.name, got, ok, err, test.want, test.wantOk, test.wantErr)
+		}
+	}
+}
+
+func TestParsePath(t *testing.T) {
+	tests := []struct {
+		path string
+		want []string
+	}{
+		{"activeProfile", []string{"activeProfile"}},
+		{"profiles.default.devices", []string{"profiles", "default", "devices"}},
+		{`profiles.default.devices."HUION Pad pad".buttons.1`, []string{"profiles", "default", "devices", "HUION Pad pad", "buttons", "1"}},
+		{`profiles.default.devices.HUION Pad pad.rotation`, []string{"profiles", "default", "devices", "HUION Pad pad", "rotation"}},
+		{`profiles."a.b".devices`, []string{"profiles", "a.b", "devices"}},
+		{`"with \"quotes\""`, []string{`with "quotes"`}},
+	}
+	for _, test := range tests {
+		got, err := ParsePath(test.path)
+		if err != nil {
+			t.Errorf("ParsePath(%q) failed: %v", test.path, err)
+		} else if !slices.Equal(got, test.want) {
+			t.Errorf("ParsePath(%q) = %q, want %q", test.path, got, test.want)
+		}
+	}
+}
+
+func TestParsePathErrors(t *testing.T) {
+	for _, path := range []string{"", "profiles..default", "profiles.", `"unterminated`, `"a"b`} {
+		if got, err := ParsePath(path); err == nil {
+			t.Errorf("ParsePath(%q) = %q, want an error", path, got)
+		}
+	}
+}
+
+func TestFormatPath(t *testing.T) {
+	tests := []struct {
+		path []string
+		want string
+	}{
+		{[]string{"activeProfile"}, "activeProfile"},
+		{[]string{"profiles", "default", "devices", "HUION Pad pad", "buttons", "1"}, `profiles.default.devices."HUION Pad pad".buttons.1`},
+		{[]string{"profiles", "a.b"}, `profiles."a.b"`},
+		{[]string{"profiles", ""}, `profiles.""`},
+	}
+	for _, test := range tests {
+		got := FormatPath(test.path)
+		if got != test.want {
+			t.Errorf("FormatPath(%q) = %q, want %q", test.path, got, test.want)
+		}
+		if test.path[len(test.path)-1] == "" {
+			continue
+		}
+		if parsed, err := ParsePath(got); err != nil || !slices.Equal(parsed, test.path) {
+			t.Errorf("ParsePath(%q) = %q, %v, want %q", got, parsed, err, test.path)
+		}
+	}
+}
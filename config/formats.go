@@ -0,0 +1,321 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Format is the file format of a config, picked by the file extension.
+// Every format is converted to and from the JSON document, so they hold the
+// same values.
+type Format string
+
+const (
+	FORMAT_JSON Format = "json"
+	FORMAT_YAML Format = "yaml"
+	FORMAT_TOML Format = "toml"
+)
+
+var formatExtensions = map[string]Format{
+	".json": FORMAT_JSON,
+	".yaml": FORMAT_YAML,
+	".yml":  FORMAT_YAML,
+	".toml": FORMAT_TOML,
+}
+
+// FormatOf returns the format of the config file at confPath. Files with an
+// unknown extension, like the legacy ~/.tablet-mapper.conf, are JSON.
+func FormatOf(confPath string) Format {
+	if format, ok := formatExtensions[strings.ToLower(path.Ext(confPath))]; ok {
+		return format
+	}
+	return FORMAT_JSON
+}
+
+func ParseFormat(name string) (Format, error) {
+	switch format := Format(strings.ToLower(name)); format {
+	case FORMAT_JSON, FORMAT_YAML, FORMAT_TOML:
+		return format, nil
+	case "yml":
+		return FORMAT_YAML, nil
+	}
+	return "", fmt.Errorf("Unknown config format '%s', expected json, yaml or toml", name)
+}
+
+// decodeFile converts the content of a config file to JSON. lines maps the
+// paths of the values to their line in buf, it is nil if the format doesn't
+// keep track of them.
+func decodeFile(file string, format Format, buf []byte) ([]byte, map[string]int, error) {
+	switch format {
+	case FORMAT_YAML:
+		var root yaml.Node
+		if err := yaml.Unmarshal(buf, &root); err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", file, err)
+		}
+		lines := map[string]int{}
+		tree, err := yamlTree(&root, nil, lines)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", file, err)
+		}
+		if tree == nil {
+			tree = map[string]any{}
+		}
+		converted, err := json.Marshal(tree)
+		return converted, lines, err
+	case FORMAT_TOML:
+		tree := map[string]any{}
+		if _, err := toml.Decode(string(buf), &tree); err != nil {
+			var parseErr toml.ParseError
+			if errors.As(err, &parseErr) {
+				// Drop the "toml: line N" prefix, the line is reported separately.
+				_, message, _ := strings.Cut(parseErr.Error(), ": ")
+				if _, rest, ok := strings.Cut(message, ": "); ok {
+					message = rest
+				}
+				return nil, nil, ValidationError{File: file, Line: parseErr.Position.Line, Message: message}
+			}
+			return nil, nil, fmt.Errorf("%s: %w", file, err)
+		}
+		converted, err := json.Marshal(tree)
+		return converted, nil, err
+	}
+	return buf, jsonLineIndex(buf), nil
+}
+
+// describeDecodeError adds the file and line to errors decoding the JSON
+// converted from a file with the given format.
+func describeDecodeError(file string, format Format, buf []byte, lines map[string]int, err error) error {
+	if format == FORMAT_JSON {
+		return describeJSONError(file, buf, err)
+	}
+	return describeTypeError(file, lines, err)
+}
+
+// describeTypeError adds the file and the line recorded in lines for the
+// path of the value to type errors.
+func describeTypeError(file string, lines map[string]int, err error) error {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		path := FormatPath(strings.Split(typeErr.Field, "."))
+		return ValidationError{
+			File:    file,
+			Line:    lines[path],
+			Path:    path,
+			Message: fmt.Sprintf("expected %s, got %s", typeErr.Type, typeErr.Value),
+		}
+	}
+	return fmt.Errorf("%s: %w", file, err)
+}
+
+// yamlTree converts a YAML node to the values encoding/json decodes to,
+// recording the line of every key and list item.
+func yamlTree(node *yaml.Node, path []string, lines map[string]int) (any, error) {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		if len(node.Content) == 0 {
+			return nil, nil
+		}
+		return yamlTree(node.Content[0], path, lines)
+	case yaml.AliasNode:
+		return yamlTree(node.Alias, path, lines)
+	case yaml.MappingNode:
+		object := map[string]any{}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if key.Kind != yaml.ScalarNode {
+				return nil, fmt.Errorf("line %d: keys must be plain values", key.Line)
+			}
+			child := join(path, key.Value)
+			lines[FormatPath(child)] = key.Line
+			converted, err := yamlTree(value, child, lines)
+			if err != nil {
+				return nil, err
+			}
+			object[key.Value] = converted
+		}
+		return object, nil
+	case yaml.SequenceNode:
+		list := make([]any, 0, len(node.Content))
+		for i, item := range node.Content {
+			child := join(path, fmt.Sprint(i))
+			lines[FormatPath(child)] = item.Line
+			converted, err := yamlTree(item, child, lines)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, converted)
+		}
+		return list, nil
+	}
+	var value any
+	if err := node.Decode(&value); err != nil {
+		return nil, fmt.Errorf("line %d: %w", node.Line, err)
+	}
+	return value, nil
+}
+
+// EncodeDocument encodes doc in the given format. For YAML and TOML, the
+// comments of previous, the current content of the file, are kept on the
+// values which are still there.
+func EncodeDocument(doc Document, format Format, previous []byte) ([]byte, error) {
+	buf, err := MarshalDocument(doc)
+	if err != nil {
+		return nil, err
+	}
+	if format == FORMAT_JSON {
+		return append(buf, '\n'), nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	dec.UseNumber()
+	if format == FORMAT_TOML {
+		var tree any
+		if err = dec.Decode(&tree); err != nil {
+			return nil, err
+		}
+		out := bytes.Buffer{}
+		enc := toml.NewEncoder(&out)
+		enc.Indent = ""
+		if err = enc.Encode(tomlValue(tree)); err != nil {
+			return nil, err
+		}
+		return readTOMLComments(previous).apply(out.Bytes()), nil
+	}
+
+	node, err := yamlNode(dec)
+	if err != nil {
+		return nil, err
+	}
+	root := &yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{node}}
+	var old yaml.Node
+	if len(previous) > 0 && yaml.Unmarshal(previous, &old) == nil {
+		copyComments(root, &old)
+	}
+	out := bytes.Buffer{}
+	enc := yaml.NewEncoder(&out)
+	enc.SetIndent(2)
+	if err = enc.Encode(root); err != nil {
+		return nil, err
+	}
+	err = enc.Close()
+	return out.Bytes(), err
+}
+
+// yamlNode builds a YAML node from the next JSON value of dec, keeping the
+// order of the keys. Null values are left out.
+func yamlNode(dec *json.Decoder) (*yaml.Node, error) {
+	token, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch value := token.(type) {
+	case json.Delim:
+		node := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		if value == '[' {
+			node = &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Style: yaml.FlowStyle}
+		}
+		for dec.More() {
+			var key *yaml.Node
+			if node.Kind == yaml.MappingNode {
+				name, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				key = &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: name.(string)}
+			}
+			child, err := yamlNode(dec)
+			if err != nil {
+				return nil, err
+			}
+			if child == nil {
+				continue
+			}
+			if child.Kind != yaml.ScalarNode && child.Kind != yaml.SequenceNode {
+				node.Style = 0
+			}
+			if key != nil {
+				node.Content = append(node.Content, key)
+			}
+			node.Content = append(node.Content, child)
+		}
+		if len(node.Content) == 0 {
+			node.Style = yaml.FlowStyle
+		}
+		_, err = dec.Token()
+		return node, err
+	case string:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}, nil
+	case json.Number:
+		tag := "!!int"
+		if strings.ContainsAny(value.String(), ".eE") {
+			tag = "!!float"
+		}
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: tag, Value: value.String()}, nil
+	case bool:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: fmt.Sprint(value)}, nil
+	}
+	return nil, nil
+}
+
+// copyComments copies the comments of old to the nodes of node at the same
+// keys and list positions.
+func copyComments(node *yaml.Node, old *yaml.Node) {
+	node.HeadComment = old.HeadComment
+	node.LineComment = old.LineComment
+	node.FootComment = old.FootComment
+	switch {
+	case node.Kind == yaml.DocumentNode && old.Kind == yaml.DocumentNode:
+		if len(node.Content) > 0 && len(old.Content) > 0 {
+			copyComments(node.Content[0], old.Content[0])
+		}
+	case node.Kind == yaml.MappingNode && old.Kind == yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			for j := 0; j+1 < len(old.Content); j += 2 {
+				if node.Content[i].Value == old.Content[j].Value {
+					copyComments(node.Content[i], old.Content[j])
+					copyComments(node.Content[i+1], old.Content[j+1])
+					break
+				}
+			}
+		}
+	case node.Kind == yaml.SequenceNode && old.Kind == yaml.SequenceNode:
+		for i := 0; i < len(node.Content) && i < len(old.Content); i++ {
+			copyComments(node.Content[i], old.Content[i])
+		}
+	}
+}
+
+// tomlValue converts a decoded JSON value to values the TOML encoder writes
+// as integers, floats and tables. TOML has no null, so null values are left
+// out.
+func tomlValue(value any) any {
+	switch value := value.(type) {
+	case map[string]any:
+		table := make(map[string]any, len(value))
+		for key, child := range value {
+			if child != nil {
+				table[key] = tomlValue(child)
+			}
+		}
+		return table
+	case []any:
+		list := make([]any, 0, len(value))
+		for _, child := range value {
+			list = append(list, tomlValue(child))
+		}
+		return list
+	case json.Number:
+		if i, err := value.Int64(); err == nil {
+			return i
+		}
+		f, _ := value.Float64()
+		return f
+	}
+	return value
+}
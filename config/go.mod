@@ -1,3 +1,8 @@
 module tablet_mapper/config
 
 go 1.21.5
+
+require (
+	github.com/BurntSushi/toml v1.4.0
+	gopkg.in/yaml.v3 v3.0.1
+)
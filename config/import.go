@@ -0,0 +1,518 @@
+package config
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"tablet_mapper/inputs"
+	"tablet_mapper/outputs"
+)
+
+// shellWord is a word of a shell command after expanding variables. Known
+// is false when its value is only known when the script runs, e.g. because
+// it comes from a command substitution. Source is then that command or the
+// name of the variable.
+type shellWord struct {
+	text   string
+	known  bool
+	source string
+}
+
+var assignmentPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*=`)
+var grepPattern = regexp.MustCompile(`grep(?:\s+-\w+)*\s+(?:"([^"]*)"|'([^']*)'|([^\s|)]+))`)
+
+// ignoredCommands don't change any device and are skipped without a note.
+var ignoredCommands = []string{"set", "echo", "printf", "exit", "sleep", ":", "true", "export",
+	"grep", "cut", "head", "tail", "tee", "cat", "awk", "sed", "xrandr"}
+
+// scriptImporter converts the commands of a script to device configs.
+type scriptImporter struct {
+	file      string
+	connected []inputs.Input
+	vars      map[string]shellWord
+	devices   TabletMapperConfig
+	problems  []ValidationError
+	line      int
+}
+
+// ImportScript converts a shell script configuring a tablet with xsetwacom
+// and xinput, like the tablet-mapper-script-old.sh this project started as,
+// to device configs. It understands
+//
+//	xsetwacom --set <device> Button <n> <action>
+//	xsetwacom --set <device> MapToOutput <output or WxH+X+Y>
+//	xsetwacom --set <device> Mode Absolute|Relative
+//	xsetwacom --set <device> Area <x1> <y1> <x2> <y2>
+//	xinput set-prop <device> "Coordinate Transformation Matrix" <9 values>
+//	xinput map-to-output <device> <output>
+//
+// and variables set to plain values. Devices given by id, or by a variable
+// set from a command like `xinput | grep "Pen stylus"`, are looked up in the
+// connected inputs. Every line which changes something but can't be
+// translated is returned as a problem.
+func ImportScript(file string, script string, connected []inputs.Input) (TabletMapperConfig, []ValidationError) {
+	im := &scriptImporter{file: file, connected: connected, vars: map[string]shellWord{}, devices: TabletMapperConfig{}}
+	lines := strings.Split(script, "\n")
+	for i := 0; i < len(lines); i++ {
+		im.line = i + 1
+		line := lines[i]
+		for strings.HasSuffix(line, "\\") && i+1 < len(lines) {
+			i++
+			line = strings.TrimSuffix(line, "\\") + lines[i]
+		}
+		commands, err := im.splitCommands(line)
+		if err != nil {
+			im.problem(line, "%s", err.Error())
+			continue
+		}
+		for _, words := range commands {
+			im.command(line, words)
+		}
+	}
+	return im.devices, im.problems
+}
+
+func (im *scriptImporter) problem(line string, format string, args ...any) {
+	im.problems = append(im.problems, ValidationError{
+		File:    im.file,
+		Line:    im.line,
+		Message: fmt.Sprintf(format, args...) + ": " + strings.TrimSpace(line),
+	})
+}
+
+// splitCommands splits a line into the words of its commands, which may be
+// separated by ;, &&, ||, | or &, expanding variables and dropping comments.
+func (im *scriptImporter) splitCommands(line string) ([][]shellWord, error) {
+	commands := make([][]shellWord, 0)
+	words := make([]shellWord, 0)
+	word := shellWord{known: true}
+	started := false
+	finishWord := func() {
+		if started {
+			words = append(words, word)
+		}
+		word, started = shellWord{known: true}, false
+	}
+	finishCommand := func() {
+		finishWord()
+		if len(words) > 0 {
+			commands = append(commands, words)
+		}
+		words = make([]shellWord, 0)
+	}
+	expand := func(value shellWord) {
+		word.text += value.text
+		if !value.known {
+			word.known, word.source = false, value.source
+		}
+	}
+
+	runes := []rune(line)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case r == ' ' || r == '\t':
+			finishWord()
+		case r == '#' && !started:
+			finishCommand()
+			return commands, nil
+		case r == ';' || r == '&' || r == '|':
+			// Commands in a pipe or the background still run, so they are
+			// translated like any other.
+			if r != ';' && i+1 < len(runes) && runes[i+1] == r {
+				i++
+			}
+			finishCommand()
+		case r == '>' || r == '<':
+			// Drop redirections like >/dev/null and 2>&1 with their target.
+			if started && strings.Trim(word.text, "0123456789") == "" {
+				word, started = shellWord{known: true}, false
+			}
+			finishWord()
+			for i+1 < len(runes) && (runes[i+1] == '>' || runes[i+1] == '&') {
+				i++
+			}
+			for i+1 < len(runes) && (runes[i+1] == ' ' || runes[i+1] == '\t') {
+				i++
+			}
+			for i+1 < len(runes) && !strings.ContainsRune(" \t;", runes[i+1]) {
+				i++
+			}
+		case r == '\\':
+			started = true
+			if i+1 < len(runes) {
+				i++
+				word.text += string(runes[i])
+			}
+		case r == '\'':
+			started = true
+			end := strings.IndexRune(string(runes[i+1:]), '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated quote")
+			}
+			quoted := []rune(string(runes[i+1:])[:end])
+			word.text += string(quoted)
+			i += len(quoted) + 1
+		case r == '"':
+			started = true
+			i++
+			for ; i < len(runes) && runes[i] != '"'; i++ {
+				switch {
+				case runes[i] == '\\' && i+1 < len(runes) && strings.ContainsRune("$`\"\\", runes[i+1]):
+					i++
+					word.text += string(runes[i])
+				case runes[i] == '$' || runes[i] == '`':
+					value, next, err := im.expansion(runes, i)
+					if err != nil {
+						return nil, err
+					}
+					expand(value)
+					i = next - 1
+				default:
+					word.text += string(runes[i])
+				}
+			}
+			if i >= len(runes) {
+				return nil, fmt.Errorf("unterminated quote")
+			}
+		case r == '$' || r == '`':
+			started = true
+			value, next, err := im.expansion(runes, i)
+			if err != nil {
+				return nil, err
+			}
+			expand(value)
+			i = next - 1
+		default:
+			started = true
+			word.text += string(r)
+		}
+	}
+	finishCommand()
+	return commands, nil
+}
+
+// expansion expands the variable or command substitution starting at
+// runes[start]. It returns its value and the index after it.
+func (im *scriptImporter) expansion(runes []rune, start int) (shellWord, int, error) {
+	if runes[start] == '`' {
+		end := strings.IndexRune(string(runes[start+1:]), '`')
+		if end < 0 {
+			return shellWord{}, 0, fmt.Errorf("unterminated command substitution")
+		}
+		command := []rune(string(runes[start+1:])[:end])
+		return shellWord{source: string(command)}, start + len(command) + 2, nil
+	}
+	i := start + 1
+	if i < len(runes) && runes[i] == '(' {
+		depth := 0
+		for j := i; j < len(runes); j++ {
+			switch runes[j] {
+			case '(':
+				depth++
+			case ')':
+				depth--
+				if depth == 0 {
+					return shellWord{source: string(runes[i+1 : j])}, j + 1, nil
+				}
+			}
+		}
+		return shellWord{}, 0, fmt.Errorf("unterminated command substitution")
+	}
+	braced := i < len(runes) && runes[i] == '{'
+	if braced {
+		i++
+	}
+	nameStart := i
+	for i < len(runes) && (runes[i] == '_' || runes[i] >= 'a' && runes[i] <= 'z' || runes[i] >= 'A' && runes[i] <= 'Z' || i > nameStart && runes[i] >= '0' && runes[i] <= '9') {
+		i++
+	}
+	name := string(runes[nameStart:i])
+	if braced {
+		if i >= len(runes) || runes[i] != '}' {
+			return shellWord{}, 0, fmt.Errorf("unsupported parameter expansion")
+		}
+		i++
+	}
+	if name == "" {
+		return shellWord{text: "$", known: true}, start + 1, nil
+	}
+	value, ok := im.vars[name]
+	if !ok {
+		return shellWord{source: "$" + name}, i, nil
+	}
+	return value, i, nil
+}
+
+func (im *scriptImporter) command(line string, words []shellWord) {
+	if assignmentPattern.MatchString(words[0].text) {
+		if len(words) > 1 {
+			im.problem(line, "commands with variable assignments aren't supported")
+			return
+		}
+		name, _, _ := strings.Cut(words[0].text, "=")
+		value := words[0]
+		value.text = strings.TrimPrefix(value.text, name+"=")
+		im.vars[name] = value
+		return
+	}
+	if !words[0].known {
+		im.problem(line, "unknown command %s", words[0].source)
+		return
+	}
+	switch path.Base(words[0].text) {
+	case "xsetwacom":
+		im.xsetwacom(line, words[1:])
+	case "xinput":
+		im.xinput(line, words[1:])
+	default:
+		for _, ignored := range ignoredCommands {
+			if words[0].text == ignored {
+				return
+			}
+		}
+		im.problem(line, "unsupported command '%s'", words[0].text)
+	}
+}
+
+// device returns the name of the device word refers to.
+func (im *scriptImporter) device(line string, word shellWord) (string, bool) {
+	name := word.text
+	if !word.known {
+		match := grepPattern.FindAllStringSubmatch(word.source, -1)
+		if len(match) == 0 {
+			im.problem(line, "device %s is only known when the script runs", word.source)
+			return "", false
+		}
+		last := match[len(match)-1]
+		name = last[1] + last[2] + last[3]
+	}
+	if id, err := strconv.Atoi(name); err == nil {
+		for _, input := range im.connected {
+			if input.Id == id {
+				return input.Name, true
+			}
+		}
+		im.problem(line, "no connected device with id %d", id)
+		return "", false
+	}
+	connected := TabletMapperConfig{}
+	for _, input := range im.connected {
+		connected[input.Name] = inputs.InputConfig{}
+	}
+	resolved, ok, err := ResolveDevice(connected, name)
+	if err != nil {
+		im.problem(line, "%s", err.Error())
+		return "", false
+	}
+	if ok {
+		return resolved, true
+	}
+	if !word.known {
+		im.problem(line, "no connected device matching '%s' for %s", name, word.source)
+		return "", false
+	}
+	return name, true
+}
+
+// output returns the output name word refers to, empty for the primary
+// output.
+func (im *scriptImporter) output(line string, word shellWord) (string, bool) {
+	if word.known {
+		return word.text, true
+	}
+	if strings.Contains(word.source, "xrandr") && strings.Contains(word.source, "primary") {
+		return "", true
+	}
+	im.problem(line, "output %s is only known when the script runs", word.source)
+	return "", false
+}
+
+func (im *scriptImporter) xsetwacom(line string, args []shellWord) {
+	if len(args) == 0 {
+		return
+	}
+	switch args[0].text {
+	case "--set", "set", "-s":
+	case "--get", "get", "-g", "--list", "list", "--version", "-V", "--help", "-h":
+		return
+	default:
+		im.problem(line, "unsupported xsetwacom command '%s'", args[0].text)
+		return
+	}
+	// Parameters may be passed as one word, e.g. "Button 1".
+	params := make([]shellWord, 0, len(args))
+	for i, arg := range args[1:] {
+		if i == 1 && arg.known && strings.Contains(arg.text, " ") {
+			for _, field := range strings.Fields(arg.text) {
+				params = append(params, shellWord{text: field, known: true})
+			}
+			continue
+		}
+		params = append(params, arg)
+	}
+	if len(params) < 3 {
+		im.problem(line, "missing xsetwacom arguments")
+		return
+	}
+	device, ok := im.device(line, params[0])
+	if !ok {
+		return
+	}
+	config := im.devices[device]
+	values := params[2:]
+	for _, value := range values {
+		if !value.known {
+			if strings.EqualFold(params[1].text, "MapToOutput") {
+				break
+			}
+			im.problem(line, "value %s is only known when the script runs", value.source)
+			return
+		}
+	}
+	switch strings.ToLower(params[1].text) {
+	case "button":
+		if len(values) < 2 {
+			im.problem(line, "missing button action")
+			return
+		}
+		if number, err := strconv.Atoi(values[0].text); err != nil || number < 1 {
+			im.problem(line, "invalid button number '%s'", values[0].text)
+			return
+		}
+		texts := make([]string, 0, len(values)-1)
+		for _, value := range values[1:] {
+			texts = append(texts, value.text)
+		}
+		action := strings.Join(texts, " ")
+		if err := inputs.ValidateButtonAction(action); err != nil {
+			im.problem(line, "%s", err.Error())
+			return
+		}
+		if config.Buttons == nil {
+			config.Buttons = map[string]string{}
+		}
+		config.Buttons[values[0].text] = action
+	case "maptooutput":
+		target := values[0].text
+		if !values[0].known {
+			output, ok := im.output(line, values[0])
+			if !ok {
+				return
+			}
+			config.MappingType, config.OutputName = inputs.INPUT_MAPPING_OUTPUT, output
+			break
+		}
+		switch {
+		case strings.EqualFold(target, "desktop"):
+			config.MappingType = inputs.INPUT_MAPPING_DESKTOP
+		case strings.HasPrefix(target, "HEAD-") || strings.EqualFold(target, "next"):
+			im.problem(line, "%s depends on the graphics driver, map to an output name instead", target)
+			return
+		default:
+			if _, err := outputs.ParseGeometry(target); err == nil {
+				config.MappingType, config.Region = inputs.INPUT_MAPPING_REGION, target
+			} else {
+				config.MappingType, config.OutputName = inputs.INPUT_MAPPING_OUTPUT, target
+			}
+		}
+	case "mode":
+		mode := strings.ToLower(values[0].text)
+		if mode != inputs.INPUT_MODE_ABSOLUTE && mode != inputs.INPUT_MODE_RELATIVE {
+			im.problem(line, "unknown mode '%s'", values[0].text)
+			return
+		}
+		config.Mode = mode
+	case "area":
+		texts := make([]string, 0, 4)
+		for _, value := range values {
+			texts = append(texts, strings.Fields(value.text)...)
+		}
+		area := make([]int, 0, 4)
+		for _, text := range texts {
+			number, err := strconv.Atoi(text)
+			if err != nil {
+				im.problem(line, "invalid tablet area value '%s'", text)
+				return
+			}
+			area = append(area, number)
+		}
+		if len(area) != 4 {
+			im.problem(line, "expected 4 tablet area values, got %d", len(area))
+			return
+		}
+		config.TabletArea = area
+	default:
+		im.problem(line, "xsetwacom parameter '%s' isn't supported", params[1].text)
+		return
+	}
+	im.devices[device] = config
+}
+
+func (im *scriptImporter) xinput(line string, args []shellWord) {
+	if len(args) == 0 {
+		return
+	}
+	switch strings.TrimPrefix(args[0].text, "--") {
+	case "set-prop", "set-float-prop":
+		im.setProp(line, args[1:])
+	case "map-to-output":
+		if len(args) != 3 {
+			im.problem(line, "expected a device and an output")
+			return
+		}
+		device, ok := im.device(line, args[1])
+		if !ok {
+			return
+		}
+		output, ok := im.output(line, args[2])
+		if !ok {
+			return
+		}
+		config := im.devices[device]
+		config.MappingType, config.OutputName = inputs.INPUT_MAPPING_OUTPUT, output
+		im.devices[device] = config
+	case "list", "list-props", "query-state", "version", "list-props-all", "help":
+	default:
+		im.problem(line, "unsupported xinput command '%s'", args[0].text)
+	}
+}
+
+func (im *scriptImporter) setProp(line string, args []shellWord) {
+	values := make([]shellWord, 0, len(args))
+	for _, arg := range args {
+		if !strings.HasPrefix(arg.text, "--type=") && !strings.HasPrefix(arg.text, "--format=") {
+			values = append(values, arg)
+		}
+	}
+	if len(values) < 2 {
+		im.problem(line, "missing xinput arguments")
+		return
+	}
+	if values[1].text != inputs.COORD_MATRIX_PROPERTY {
+		im.problem(line, "property '%s' isn't supported", values[1].text)
+		return
+	}
+	if len(values) != 11 {
+		im.problem(line, "expected 9 matrix values, got %d", len(values)-2)
+		return
+	}
+	var m inputs.CoordinationMatrix
+	for i, value := range values[2:] {
+		number, err := strconv.ParseFloat(strings.TrimSuffix(value.text, ","), 32)
+		if err != nil || !value.known {
+			im.problem(line, "invalid matrix value '%s'", value.text)
+			return
+		}
+		m[i/3][i%3] = float32(number)
+	}
+	device, ok := im.device(line, values[0])
+	if !ok {
+		return
+	}
+	config := im.devices[device]
+	config.MappingType, config.CoordMatrix = inputs.INPUT_MAPPING_COORD_MATRIX, m
+	im.devices[device] = config
+}
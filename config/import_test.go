@@ -0,0 +1,110 @@
+package config
+
+import (
+	"os"
+	"reflect"
+	"tablet_mapper/inputs"
+	"testing"
+)
+
+var importConnected = []inputs.Input{
+	{Id: 12, Name: "HUION H420 Pen stylus"},
+	{Id: 13, Name: "HUION H420 Pad pad"},
+}
+
+func problemStrings(problems []ValidationError) []string {
+	lines := make([]string, 0, len(problems))
+	for _, problem := range problems {
+		lines = append(lines, problem.Error())
+	}
+	return lines
+}
+
+func TestImportScriptOldScript(t *testing.T) {
+	script, err := os.ReadFile("../tablet-mapper-script-old.sh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	devices, problems := ImportScript("old.sh", string(script), importConnected)
+	want := TabletMapperConfig{
+		"HUION H420 Pad pad": {
+			MappingType: inputs.INPUT_MAPPING_OUTPUT,
+			Mode:        inputs.INPUT_MODE_ABSOLUTE,
+			Buttons:     map[string]string{"1": "key +ctrl +z -z -ctrl", "2": "key e", "3": "key h"},
+		},
+		"HUION H420 Pen stylus": {
+			MappingType: inputs.INPUT_MAPPING_OUTPUT,
+			Mode:        inputs.INPUT_MODE_ABSOLUTE,
+		},
+	}
+	if !sameJSON(devices, want) {
+		t.Errorf("ImportScript devices = %+v, want %+v", devices, want)
+	}
+	wantProblems := []string{
+		`old.sh:21: HEAD-0 depends on the graphics driver, map to an output name instead: xsetwacom --set "$ID_STYLUS" MapToOutput "HEAD-0"`,
+		`old.sh:22: HEAD-0 depends on the graphics driver, map to an output name instead: xsetwacom --set "$PAD_NAME" MapToOutput "HEAD-0"`,
+	}
+	if got := problemStrings(problems); !reflect.DeepEqual(got, wantProblems) {
+		t.Errorf("ImportScript problems = %q, want %q", got, wantProblems)
+	}
+}
+
+func TestImportScript(t *testing.T) {
+	tests := []struct {
+		name     string
+		script   string
+		want     TabletMapperConfig
+		problems int
+	}{
+		{
+			"mode and output by name",
+			"xsetwacom --set 'HUION H420 Pen stylus' Mode Relative\nxsetwacom set stylus MapToOutput DP-2\n",
+			TabletMapperConfig{"HUION H420 Pen stylus": {MappingType: inputs.INPUT_MAPPING_OUTPUT, OutputName: "DP-2", Mode: inputs.INPUT_MODE_RELATIVE}},
+			0,
+		},
+		{
+			"tablet area",
+			"xsetwacom --set 'HUION H420 Pen stylus' Area 0 0 16000 10000\n",
+			TabletMapperConfig{"HUION H420 Pen stylus": {TabletArea: []int{0, 0, 16000, 10000}}},
+			0,
+		},
+		{
+			"matrix by id",
+			`xinput set-prop 12 "Coordinate Transformation Matrix" 0.5 0 0 0 1 0 0 0 1`,
+			TabletMapperConfig{"HUION H420 Pen stylus": {
+				MappingType: inputs.INPUT_MAPPING_COORD_MATRIX,
+				CoordMatrix: inputs.CoordinationMatrix{{0.5, 0, 0}, {0, 1, 0}, {0, 0, 1}},
+			}},
+			0,
+		},
+		{
+			"continued line and variable",
+			"PAD=pad\nxsetwacom --set \"$PAD\" \\\n  Button 8 'key ctrl'\n",
+			TabletMapperConfig{"HUION H420 Pad pad": {Buttons: map[string]string{"8": "key ctrl"}}},
+			0,
+		},
+		{
+			"unknown id and command",
+			"xinput map-to-output 99 DP-2\nnotify-send done\n",
+			TabletMapperConfig{},
+			2,
+		},
+		{
+			"unsupported property",
+			`xinput set-prop 12 "Device Enabled" 0`,
+			TabletMapperConfig{},
+			1,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			devices, problems := ImportScript("test.sh", test.script, importConnected)
+			if !sameJSON(devices, test.want) {
+				t.Errorf("ImportScript devices = %+v, want %+v", devices, test.want)
+			}
+			if len(problems) != test.problems {
+				t.Errorf("ImportScript problems = %q, want %d", problemStrings(problems), test.problems)
+			}
+		})
+	}
+}
@@ -0,0 +1,311 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const SYSTEM_CONFIG_DIR = "/etc/tablet-mapper"
+
+const (
+	ENV_CONFIG  = "TABLET_MAPPER_CONFIG"
+	ENV_PROFILE = "TABLET_MAPPER_PROFILE"
+)
+
+// Layer is one source of config values. Layers are merged in order, values
+// of later layers replacing those of earlier ones.
+type Layer struct {
+	Origin string
+	doc    map[string]any
+	// lines maps paths to the line they are on in the layer's file. It is
+	// nil for layers not read from a file.
+	lines map[string]int
+}
+
+type Origin struct {
+	Layer string
+	// Line is the line of the value in the layer's file, 0 if unknown.
+	Line  int
+	path  []string
+	lines map[string]int
+}
+
+func (origin Origin) String() string {
+	if origin.Line > 0 {
+		return fmt.Sprintf("%s:%d", origin.Layer, origin.Line)
+	}
+	return origin.Layer
+}
+
+// Origins maps the path of every value of the effective document to the
+// layer it came from.
+type Origins map[string]Origin
+
+// SystemLayers reads the admin defaults from SYSTEM_CONFIG_DIR in file name
+// order. Files which can't be read are skipped.
+func SystemLayers() []Layer {
+	paths := []string{}
+	for extension := range formatExtensions {
+		matches, _ := filepath.Glob(filepath.Join(SYSTEM_CONFIG_DIR, "*"+extension))
+		paths = append(paths, matches...)
+	}
+	sort.Strings(paths)
+	layers := make([]Layer, 0, len(paths))
+	for _, path := range paths {
+		if layer, err := FileLayer(path); err != nil {
+			log.Printf("WARN: skipping system config '%s'. %s", path, err.Error())
+		} else {
+			layers = append(layers, layer)
+		}
+	}
+	return layers
+}
+
+// FileLayer reads a config file as a layer. Syntax and type errors are
+// reported with the line they are on.
+func FileLayer(confPath string) (Layer, error) {
+	buf, err := os.ReadFile(confPath)
+	if err != nil {
+		return Layer{}, err
+	}
+	format := FormatOf(confPath)
+	converted, lines, err := decodeFile(confPath, format, buf)
+	if err != nil {
+		return Layer{}, err
+	}
+	upgraded, version, err := migrate(converted)
+	if err != nil {
+		return Layer{}, describeDecodeError(confPath, format, converted, lines, err)
+	}
+	if version != CONFIG_VERSION {
+		lines = migrateLines(converted, lines, version)
+	}
+	if _, _, err = ParseDocument(upgraded); err != nil {
+		if version != CONFIG_VERSION {
+			// Offsets in the upgraded document don't match the file.
+			return Layer{}, describeTypeError(confPath, lines, err)
+		}
+		return Layer{}, describeDecodeError(confPath, format, converted, lines, err)
+	}
+	var doc map[string]any
+	if err = json.Unmarshal(upgraded, &doc); err != nil {
+		return Layer{}, err
+	}
+	return Layer{Origin: confPath, doc: doc, lines: lines}, nil
+}
+
+// ProfileLayer selects the active profile, e.g. from an environment
+// variable or a command line flag.
+func ProfileLayer(origin string, profile string) Layer {
+	return Layer{Origin: origin, doc: map[string]any{"activeProfile": profile}}
+}
+
+// ReadEffectiveDocument merges the system layers, the user config at
+// confPath and the profile selected by the environment and the --profile
+// flag (profileFlag). A missing user config is skipped.
+func ReadEffectiveDocument(confPath string, profileFlag string) (Document, Origins, error) {
+	layers := SystemLayers()
+	if _, err := os.Stat(confPath); err == nil {
+		layer, err := FileLayer(confPath)
+		if err != nil {
+			return Document{}, nil, fmt.Errorf("Couldn't read config file '%s'. %w", confPath, err)
+		}
+		layers = append(layers, layer)
+	} else {
+		log.Printf("WARN: couldn't read from config file '%s'. %s", confPath, err.Error())
+	}
+	if profile := os.Getenv(ENV_PROFILE); profile != "" {
+		layers = append(layers, ProfileLayer("$"+ENV_PROFILE, profile))
+	}
+	if profileFlag != "" {
+		layers = append(layers, ProfileLayer("--profile", profileFlag))
+	}
+	return MergeLayers(layers)
+}
+
+func MergeLayers(layers []Layer) (Document, Origins, error) {
+	merged := map[string]any{}
+	origins := Origins{}
+	for _, layer := range layers {
+		mergeInto(merged, layer.doc, layer, nil, origins)
+	}
+	merged["version"] = CONFIG_VERSION
+	if _, ok := merged["profiles"]; !ok {
+		merged["profiles"] = map[string]any{DEFAULT_PROFILE: map[string]any{"devices": map[string]any{}}}
+	}
+	buf, err := json.Marshal(merged)
+	if err != nil {
+		return Document{}, nil, err
+	}
+	doc, _, err := ParseDocument(buf)
+	return doc, origins, err
+}
+
+// mergeInto merges objects key by key and replaces any other value. Null
+// values are treated as unset.
+func mergeInto(dst map[string]any, src map[string]any, layer Layer, prefix []string, origins Origins) {
+	for key, value := range src {
+		if value == nil || (prefix == nil && key == "version") {
+			continue
+		}
+		path := append(prefix[:len(prefix):len(prefix)], key)
+		if object, ok := value.(map[string]any); ok {
+			dstObject, ok := dst[key].(map[string]any)
+			if !ok {
+				delete(origins, FormatPath(path))
+				dstObject = map[string]any{}
+				dst[key] = dstObject
+			}
+			mergeInto(dstObject, object, layer, path, origins)
+			continue
+		}
+		if _, ok := dst[key].(map[string]any); ok {
+			for p := range origins {
+				if strings.HasPrefix(p, FormatPath(path)+".") {
+					delete(origins, p)
+				}
+			}
+		}
+		dst[key] = value
+		origins[FormatPath(path)] = Origin{
+			Layer: layer.Origin,
+			Line:  layer.lines[FormatPath(path)],
+			path:  path,
+			lines: layer.lines,
+		}
+	}
+}
+
+// FormatPath joins the keys of a path with dots, quoting keys which aren't
+// plain words, e.g. profiles.default.devices."HUION Pad pad".buttons.1
+func FormatPath(path []string) string {
+	parts := make([]string, 0, len(path))
+	for _, key := range path {
+		if isPlainKey(key) {
+			parts = append(parts, key)
+		} else {
+			parts = append(parts, fmt.Sprintf("%q", key))
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+// ParsePath splits a path formatted like FormatPath into its keys. Quotes
+// are optional for keys without dots.
+func ParsePath(path string) ([]string, error) {
+	keys := make([]string, 0)
+	rest := path
+	for {
+		key := ""
+		if strings.HasPrefix(rest, `"`) {
+			quoted, err := strconv.QuotedPrefix(rest)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid quoted key in path '%s'", path)
+			}
+			key, _ = strconv.Unquote(quoted)
+			rest = rest[len(quoted):]
+		} else {
+			end := strings.IndexByte(rest, '.')
+			if end < 0 {
+				end = len(rest)
+			}
+			key, rest = rest[:end], rest[end:]
+		}
+		if key == "" {
+			return nil, fmt.Errorf("Empty key in path '%s'", path)
+		}
+		keys = append(keys, key)
+		if rest == "" {
+			return keys, nil
+		}
+		if rest[0] != '.' {
+			return nil, fmt.Errorf("Expected '.' after key '%s' in path '%s'", key, path)
+		}
+		rest = rest[1:]
+	}
+}
+
+func isPlainKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, r := range key {
+		if !(r == '_' || r == '-' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
+// Leaves returns every non-object value of the effective document by path,
+// as used in Origins.
+func Leaves(doc Document) (map[string]any, error) {
+	buf, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	var tree map[string]any
+	if err = json.Unmarshal(buf, &tree); err != nil {
+		return nil, err
+	}
+	leaves := map[string]any{}
+	var walk func(object map[string]any, prefix []string)
+	walk = func(object map[string]any, prefix []string) {
+		for key, value := range object {
+			path := append(prefix[:len(prefix):len(prefix)], key)
+			if child, ok := value.(map[string]any); ok {
+				walk(child, path)
+			} else {
+				leaves[FormatPath(path)] = value
+			}
+		}
+	}
+	walk(tree, nil)
+	return leaves, nil
+}
+
+// WithoutSystemDefaults drops the devices and the layout which are the same
+// as those the system layers give the profile called name, so saving a
+// profile only writes what the user changed and later changes to the admin
+// defaults still apply. Devices are kept or dropped as a whole, as a device
+// config can't be stored in part.
+func WithoutSystemDefaults(name string, profile Profile) Profile {
+	return withoutDefaults(name, profile, SystemLayers())
+}
+
+func withoutDefaults(name string, profile Profile, layers []Layer) Profile {
+	if len(layers) == 0 {
+		return profile
+	}
+	doc, _, err := MergeLayers(layers)
+	if err != nil {
+		log.Printf("WARN: couldn't merge the system config. %s", err.Error())
+		return profile
+	}
+	defaults, ok := doc.Profiles[name]
+	if !ok {
+		return profile
+	}
+	result := Profile{Devices: TabletMapperConfig{}, Layout: profile.Layout}
+	for device, config := range profile.Devices {
+		if base, ok := defaults.Devices[device]; !ok || !sameJSON(base, config) {
+			result.Devices[device] = config
+		}
+	}
+	if profile.Layout != nil && defaults.Layout != nil && sameJSON(profile.Layout, defaults.Layout) {
+		result.Layout = nil
+	}
+	return result
+}
+
+func sameJSON(a any, b any) bool {
+	bufA, errA := json.Marshal(a)
+	bufB, errB := json.Marshal(b)
+	return errA == nil && errB == nil && string(bufA) == string(bufB)
+}
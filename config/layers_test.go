@@ -0,0 +1,59 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"tablet_mapper/inputs"
+	"testing"
+)
+
+func TestWithoutDefaults(t *testing.T) {
+	systemConfig := filepath.Join(t.TempDir(), "10-lab.json")
+	err := os.WriteFile(systemConfig, []byte(`{
+  "version": 3,
+  "profiles": {
+    "default": {
+      "devices": {
+        "HUION Pad pad": { "mappingType": "desktop", "rotation": 0, "buttons": { "1": "key +ctrl +z -z -ctrl" } },
+        "HUION H420 Pen stylus": { "mappingType": "desktop", "rotation": 0 }
+      }
+    }
+  }
+}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	layer, err := FileLayer(systemConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	layers := []Layer{layer}
+	effective, _, err := MergeLayers(layers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	devices := TabletMapperConfig{}
+	for name, config := range effective.Profiles["default"].Devices {
+		devices[name] = config
+	}
+	stylus := devices["HUION H420 Pen stylus"]
+	stylus.Rotation = 90
+	devices["HUION H420 Pen stylus"] = stylus
+	devices["Other stylus"] = inputs.InputConfig{MappingType: inputs.INPUT_MAPPING_DESKTOP}
+
+	saved := withoutDefaults("default", Profile{Devices: devices}, layers)
+	if _, ok := saved.Devices["HUION Pad pad"]; ok {
+		t.Errorf("device configured like in the system layers was saved")
+	}
+	if saved.Devices["HUION H420 Pen stylus"].Rotation != 90 {
+		t.Errorf("changed device wasn't saved: %+v", saved.Devices)
+	}
+	if _, ok := saved.Devices["Other stylus"]; !ok {
+		t.Errorf("device missing from the system layers wasn't saved")
+	}
+
+	other := withoutDefaults("docked", Profile{Devices: devices}, layers)
+	if len(other.Devices) != len(devices) {
+		t.Errorf("profile missing from the system layers lost devices: %+v", other.Devices)
+	}
+}
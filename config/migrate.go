@@ -0,0 +1,133 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// CONFIG_VERSION is the version of the document written by this tool. Files
+// without a version field are version 1, the bare device map.
+const CONFIG_VERSION = 3
+
+type migration func(doc map[string]any) (map[string]any, error)
+
+// migrations[i] upgrades a document from version i+1 to version i+2.
+var migrations = []migration{
+	migrateV1ToV2,
+	migrateV2ToV3,
+}
+
+// migrateV1ToV2 moves the devices under "devices" and renames the
+// misspelled "widowName" key.
+func migrateV1ToV2(doc map[string]any) (map[string]any, error) {
+	for name, device := range doc {
+		fields, ok := device.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("Device '%s' isn't an object", name)
+		}
+		if windowName, ok := fields["widowName"]; ok {
+			if _, ok := fields["windowName"]; !ok {
+				fields["windowName"] = windowName
+			}
+			delete(fields, "widowName")
+		}
+	}
+	return map[string]any{"version": 2, "devices": doc}, nil
+}
+
+// migrateV2ToV3 makes the devices the only profile, which is active.
+func migrateV2ToV3(doc map[string]any) (map[string]any, error) {
+	devices, ok := doc["devices"]
+	if !ok || devices == nil {
+		devices = map[string]any{}
+	}
+	return map[string]any{
+		"version":       3,
+		"activeProfile": DEFAULT_PROFILE,
+		"profiles": map[string]any{
+			DEFAULT_PROFILE: map[string]any{"devices": devices},
+		},
+	}, nil
+}
+
+// migrateLines moves the lines recorded for the paths of a document of the
+// given version, converted, to the paths its values have after migrate.
+func migrateLines(converted []byte, lines map[string]int, version int) map[string]int {
+	if lines == nil {
+		return nil
+	}
+	if version == 1 {
+		var devices map[string]any
+		json.Unmarshal(converted, &devices)
+		v2 := make(map[string]int, len(lines))
+		for path, line := range lines {
+			v2["devices."+path] = line
+		}
+		for name := range devices {
+			widowName := FormatPath([]string{name, "widowName"})
+			line, ok := lines[widowName]
+			if !ok {
+				continue
+			}
+			delete(v2, "devices."+widowName)
+			if _, ok := lines[FormatPath([]string{name, "windowName"})]; !ok {
+				v2["devices."+FormatPath([]string{name, "windowName"})] = line
+			}
+		}
+		lines = v2
+	}
+	if version <= 2 {
+		prefix := FormatPath([]string{"profiles", DEFAULT_PROFILE}) + "."
+		v3 := make(map[string]int, len(lines))
+		for path, line := range lines {
+			if path == "devices" || strings.HasPrefix(path, "devices.") {
+				path = prefix + path
+			}
+			v3[path] = line
+		}
+		lines = v3
+	}
+	return lines
+}
+
+func documentVersion(doc map[string]any) (int, error) {
+	value, ok := doc["version"]
+	if !ok {
+		return 1, nil
+	}
+	version, ok := value.(float64)
+	if !ok || version < 1 || version != float64(int(version)) {
+		return 0, fmt.Errorf("Invalid config version '%v'", value)
+	}
+	return int(version), nil
+}
+
+// migrate upgrades the JSON document in buf to CONFIG_VERSION. It returns
+// the version the document had before.
+func migrate(buf []byte) ([]byte, int, error) {
+	var doc map[string]any
+	if err := json.Unmarshal(buf, &doc); err != nil {
+		return nil, 0, err
+	}
+	if doc == nil {
+		doc = map[string]any{}
+	}
+	version, err := documentVersion(doc)
+	if err != nil {
+		return nil, 0, err
+	}
+	if version > CONFIG_VERSION {
+		return nil, version, fmt.Errorf("Config version %d is newer than the supported version %d", version, CONFIG_VERSION)
+	}
+	if version == CONFIG_VERSION {
+		return buf, version, nil
+	}
+	for v := version; v < CONFIG_VERSION; v++ {
+		if doc, err = migrations[v-1](doc); err != nil {
+			return nil, version, fmt.Errorf("Couldn't migrate config from version %d. %w", v, err)
+		}
+	}
+	upgraded, err := json.Marshal(doc)
+	return upgraded, version, err
+}
@@ -0,0 +1,112 @@
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestMigrateV1ToV2(t *testing.T) {
+	v2 := map[string]any{
+		"version": 2,
+		"devices": map[string]any{
+			"HUION H420 Pen stylus": map[string]any{"mappingType": "window", "windowName": "krita", "rotation": float64(0)},
+		},
+	}
+	tests := []struct {
+		name   string
+		config string
+		want   map[string]any
+	}{
+		{
+			"widowName",
+			`{"HUION H420 Pen stylus": {"mappingType": "window", "widowName": "krita", "rotation": 0}}`,
+			v2,
+		},
+		{
+			"both names",
+			`{"HUION H420 Pen stylus": {"mappingType": "window", "widowName": "gimp", "windowName": "krita", "rotation": 0}}`,
+			v2,
+		},
+		{
+			"windowName",
+			`{"HUION H420 Pen stylus": {"mappingType": "window", "windowName": "krita", "rotation": 0}}`,
+			v2,
+		},
+		{
+			"empty",
+			`{}`,
+			map[string]any{"version": 2, "devices": map[string]any{}},
+		},
+	}
+	for _, test := range tests {
+		var doc map[string]any
+		if err := json.Unmarshal([]byte(test.config), &doc); err != nil {
+			t.Fatal(err)
+		}
+		got, err := migrateV1ToV2(doc)
+		if err != nil {
+			t.Errorf("%s: migrateV1ToV2 failed: %v", test.name, err)
+		} else if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: migrateV1ToV2(%s) = %v, want %v", test.name, test.config, got, test.want)
+		}
+	}
+	if _, err := migrateV1ToV2(map[string]any{"HUION H420 Pen stylus": "desktop"}); err == nil {
+		t.Errorf("migrateV1ToV2 accepted a device which isn't an object")
+	}
+}
+
+func TestDocumentVersion(t *testing.T) {
+	tests := []struct {
+		config  string
+		want    int
+		wantErr bool
+	}{
+		{`{"HUION H420 Pen stylus": {}}`, 1, false},
+		{`{"version": 2, "devices": {}}`, 2, false},
+		{`{"version": "2"}`, 0, true},
+		{`{"version": 1.5}`, 0, true},
+		{`{"version": 0}`, 0, true},
+	}
+	for _, test := range tests {
+		var doc map[string]any
+		if err := json.Unmarshal([]byte(test.config), &doc); err != nil {
+			t.Fatal(err)
+		}
+		got, err := documentVersion(doc)
+		if (err != nil) != test.wantErr || got != test.want {
+			t.Errorf("documentVersion(%s) = %d, %v, want %d, error %v", test.config, got, err, test.want, test.wantErr)
+		}
+	}
+}
+
+func TestMigrateNewerVersion(t *testing.T) {
+	if _, version, err := migrate([]byte(`{"version": 99}`)); err == nil || version != 99 {
+		t.Errorf("migrate of version 99 = %d, %v, want 99 and an error", version, err)
+	}
+}
+
+func TestMigrateV2ToV3(t *testing.T) {
+	device := map[string]any{"mappingType": "desktop", "rotation": float64(0)}
+	tests := []struct {
+		name    string
+		doc     map[string]any
+		devices map[string]any
+	}{
+		{"devices", map[string]any{"version": 2, "devices": map[string]any{"HUION H420 Pen stylus": device}}, map[string]any{"HUION H420 Pen stylus": device}},
+		{"no devices", map[string]any{"version": 2}, map[string]any{}},
+	}
+	for _, test := range tests {
+		got, err := migrateV2ToV3(test.doc)
+		want := map[string]any{
+			"version":       3,
+			"activeProfile": DEFAULT_PROFILE,
+			"profiles":      map[string]any{DEFAULT_PROFILE: map[string]any{"devices": test.devices}},
+		}
+		if err != nil {
+			t.Errorf("%s: migrateV2ToV3 failed: %v", test.name, err)
+		} else if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: migrateV2ToV3 = %v, want %v", test.name, got, want)
+		}
+	}
+}
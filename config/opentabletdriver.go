@@ -0,0 +1,522 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"tablet_mapper/inputs"
+	"tablet_mapper/outputs"
+)
+
+// OTDSettings is the part of the settings.json of OpenTabletDriver which
+// has an equivalent in tablet-mapper. The field names are the ones
+// OpenTabletDriver uses.
+type OTDSettings struct {
+	Profiles []OTDProfile
+}
+
+// OTDProfile holds the settings of one tablet, which OpenTabletDriver looks
+// up by its name in Tablet.
+type OTDProfile struct {
+	Tablet               string
+	OutputMode           OTDPlugin
+	AbsoluteModeSettings OTDAbsoluteSettings
+	Bindings             OTDBindings
+}
+
+type OTDAbsoluteSettings struct {
+	Display        *OTDArea `json:",omitempty"`
+	Tablet         *OTDArea `json:",omitempty"`
+	EnableClipping bool
+}
+
+// OTDArea is an area given by its size and center, in pixels for the
+// display and in millimeters for the tablet.
+type OTDArea struct {
+	Width    float64
+	Height   float64
+	X        float64
+	Y        float64
+	Rotation float64 `json:",omitempty"`
+}
+
+// OTDBindings are the actions of the tip, the pen buttons and the buttons
+// on the tablet, which OpenTabletDriver calls aux buttons. Unbound buttons
+// are null.
+type OTDBindings struct {
+	TipButton  *OTDPlugin
+	PenButtons []*OTDPlugin
+	AuxButtons []*OTDPlugin
+}
+
+// OTDPlugin is an output mode or a binding, selected by the class in Path.
+type OTDPlugin struct {
+	Path     string
+	Settings []OTDSetting
+	Enable   bool
+}
+
+type OTDSetting struct {
+	Property string
+	Value    any
+}
+
+const (
+	OTD_ABSOLUTE_MODE     = "OpenTabletDriver.Desktop.Output.AbsoluteMode"
+	OTD_RELATIVE_MODE     = "OpenTabletDriver.Desktop.Output.RelativeMode"
+	OTD_MOUSE_BINDING     = "OpenTabletDriver.Desktop.Binding.MouseBinding"
+	OTD_KEY_BINDING       = "OpenTabletDriver.Desktop.Binding.KeyBinding"
+	OTD_MULTI_KEY_BINDING = "OpenTabletDriver.Desktop.Binding.MultiKeyBinding"
+)
+
+var otdMouseButtons = map[int]string{1: "Left", 2: "Middle", 3: "Right", 8: "Backward", 9: "Forward"}
+
+// otdKeys maps xsetwacom key names to the key names of OpenTabletDriver.
+// Letters, digits and function keys are converted by otdKey.
+var otdKeys = map[string]string{
+	"ctrl": "Control", "shift": "Shift", "alt": "Alt", "super": "Super",
+	"Return": "Enter", "Escape": "Escape", "Tab": "Tab", "space": "Space", "BackSpace": "Backspace",
+	"Delete": "Delete", "Insert": "Insert", "Home": "Home", "End": "End",
+	"Page_Up": "PageUp", "Page_Down": "PageDown", "Left": "Left", "Up": "Up", "Right": "Right", "Down": "Down",
+	"minus": "Minus", "equal": "Equals", "plus": "Plus", "comma": "Comma", "period": "Period",
+	"slash": "Slash", "semicolon": "Semicolon", "bracketleft": "OpenBracket", "bracketright": "CloseBracket",
+}
+
+var otdModifiers = []string{"Control", "Shift", "Alt", "Super"}
+
+var functionKeyPattern = regexp.MustCompile(`^F([1-9]|1[0-9]|2[0-4])$`)
+
+// otdKey returns the OpenTabletDriver name of an xsetwacom key.
+func otdKey(key string) (string, bool) {
+	if modifier, ok := inputs.ModifierName(key); ok {
+		key = modifier
+	} else if alias, ok := inputs.KeyAliases[strings.ToLower(key)]; ok {
+		key = alias
+	}
+	if name, ok := otdKeys[key]; ok {
+		return name, true
+	}
+	switch {
+	case len(key) == 1 && (key[0] >= 'a' && key[0] <= 'z' || key[0] >= 'A' && key[0] <= 'Z'):
+		return strings.ToUpper(key), true
+	case len(key) == 1 && key[0] >= '0' && key[0] <= '9':
+		return "D" + key, true
+	case functionKeyPattern.MatchString(key):
+		return key, true
+	}
+	return "", false
+}
+
+// xsetwacomKey is the reverse of otdKey.
+func xsetwacomKey(name string) (string, bool) {
+	for key, otdName := range otdKeys {
+		if otdName == name {
+			return key, true
+		}
+	}
+	switch {
+	case len(name) == 1 && name[0] >= 'A' && name[0] <= 'Z':
+		return strings.ToLower(name), true
+	case len(name) == 2 && name[0] == 'D' && name[1] >= '0' && name[1] <= '9':
+		return name[1:], true
+	case functionKeyPattern.MatchString(name):
+		return name, true
+	}
+	return "", false
+}
+
+// otdBinding converts an xsetwacom button action to a binding. Only clicks
+// and single key combinations like "key +ctrl +z -z -ctrl" have one.
+func otdBinding(action string) (*OTDPlugin, error) {
+	if number, ok := inputs.ClickedButton(action); ok {
+		name, ok := otdMouseButtons[number]
+		if !ok {
+			return nil, fmt.Errorf("mouse button %d has no OpenTabletDriver binding", number)
+		}
+		return &OTDPlugin{Path: OTD_MOUSE_BINDING, Settings: []OTDSetting{{"Button", name}}, Enable: true}, nil
+	}
+	fields := strings.Fields(action)
+	if len(fields) < 2 || !strings.EqualFold(fields[0], "key") {
+		return nil, fmt.Errorf("'%s' has no OpenTabletDriver binding, only clicks and key combinations do", action)
+	}
+	held := make([]string, 0)
+	var combination []string
+	for _, token := range fields[1:] {
+		name, ok := otdKey(strings.TrimLeft(token, "+-"))
+		if !ok {
+			return nil, fmt.Errorf("key '%s' has no OpenTabletDriver name", strings.TrimLeft(token, "+-"))
+		}
+		switch {
+		case strings.HasPrefix(token, "-"):
+			held = slices.DeleteFunc(held, func(key string) bool { return key == name })
+		case slices.Contains(otdModifiers, name):
+			if !slices.Contains(held, name) {
+				held = append(held, name)
+			}
+		case combination != nil:
+			return nil, fmt.Errorf("'%s' types several keys, OpenTabletDriver only binds one combination", action)
+		default:
+			combination = append(slices.Clone(held), name)
+		}
+	}
+	if combination == nil {
+		combination = held
+	}
+	if len(combination) == 1 {
+		return &OTDPlugin{Path: OTD_KEY_BINDING, Settings: []OTDSetting{{"Key", combination[0]}}, Enable: true}, nil
+	}
+	return &OTDPlugin{Path: OTD_MULTI_KEY_BINDING, Settings: []OTDSetting{{"Keys", strings.Join(combination, "+")}}, Enable: true}, nil
+}
+
+func (plugin OTDPlugin) setting(property string) string {
+	for _, setting := range plugin.Settings {
+		if setting.Property == property {
+			return fmt.Sprint(setting.Value)
+		}
+	}
+	return ""
+}
+
+// buttonAction is the reverse of otdBinding. A key combination becomes
+// e.g. "key +ctrl +z -z -ctrl".
+func buttonAction(binding OTDPlugin) (string, error) {
+	var keys []string
+	switch binding.Path {
+	case OTD_MOUSE_BINDING:
+		name := binding.setting("Button")
+		for number, button := range otdMouseButtons {
+			if button == name {
+				return fmt.Sprintf("button +%d", number), nil
+			}
+		}
+		return "", fmt.Errorf("unknown mouse button '%s'", name)
+	case OTD_KEY_BINDING:
+		keys = []string{binding.setting("Key")}
+	case OTD_MULTI_KEY_BINDING:
+		keys = strings.Split(binding.setting("Keys"), "+")
+	default:
+		return "", fmt.Errorf("binding %s has no xsetwacom action", binding.Path)
+	}
+	presses := make([]string, 0, len(keys))
+	releases := make([]string, 0, len(keys))
+	for _, name := range keys {
+		key, ok := xsetwacomKey(strings.TrimSpace(name))
+		if !ok {
+			return "", fmt.Errorf("key '%s' has no xsetwacom name", name)
+		}
+		presses = append(presses, "+"+key)
+		releases = append([]string{"-" + key}, releases...)
+	}
+	return "key " + strings.Join(append(presses, releases...), " "), nil
+}
+
+// otdTabletName returns the name of the tablet a device belongs to and the
+// kind of the device, e.g. "HUION H420" and "stylus" for
+// "HUION H420 Pen stylus".
+func otdTabletName(device string) (string, string) {
+	i := strings.LastIndex(device, " ")
+	if i < 0 || !slices.Contains([]string{"stylus", "eraser", "cursor", "pad", "touch"}, device[i+1:]) {
+		return device, "stylus"
+	}
+	tablet, kind := device[:i], device[i+1:]
+	for _, suffix := range []string{" Pen", " Pad"} {
+		tablet = strings.TrimSuffix(tablet, suffix)
+	}
+	return tablet, kind
+}
+
+// otdDeviceName returns the name of the connected device of the given kind
+// of tablet, or the name a HUION or Wacom tablet gives it.
+func otdDeviceName(tablet string, kind string, connected []inputs.Input) string {
+	for _, input := range connected {
+		if len(input.Name) > len(tablet) && strings.EqualFold(input.Name[:len(tablet)], tablet) && strings.HasSuffix(input.Name, " "+kind) {
+			return input.Name
+		}
+	}
+	if kind == "pad" {
+		return tablet + " Pad pad"
+	}
+	return tablet + " Pen stylus"
+}
+
+// otdAuxButton returns the index in AuxButtons of a pad button. xsetwacom
+// numbers the pad buttons 1 to 3 and 8 onwards, 4 to 7 scroll.
+func otdAuxButton(button int) (int, bool) {
+	switch {
+	case button >= 1 && button <= 3:
+		return button - 1, true
+	case button >= 8:
+		return button - 5, true
+	}
+	return 0, false
+}
+
+func padButton(aux int) int {
+	if aux < 3 {
+		return aux + 1
+	}
+	return aux + 5
+}
+
+// OTDDevice is a device to export. Display is the part of the screen it is
+// mapped to, nil if it isn't mapped or the layout is unknown.
+type OTDDevice struct {
+	Name       string
+	Display    *outputs.Rect
+	Rotation   int
+	Mode       string
+	TabletArea []int
+	Buttons    map[string]string
+}
+
+func setBinding(bindings *[]*OTDPlugin, index int, binding *OTDPlugin) {
+	for len(*bindings) <= index {
+		*bindings = append(*bindings, nil)
+	}
+	(*bindings)[index] = binding
+}
+
+// ExportOpenTabletDriver converts devices to OpenTabletDriver profiles, one
+// per tablet. The stylus device gives the areas, mode, tip and pen buttons,
+// the pad device the aux buttons. Tablet areas are converted with
+// unitsPerMM tablet units per millimeter and left out if it is 0. Settings
+// which can't be converted are returned as problems.
+func ExportOpenTabletDriver(devices []OTDDevice, unitsPerMM float64) (OTDSettings, []ValidationError) {
+	problems := make([]ValidationError, 0)
+	report := func(device string, format string, args ...any) {
+		problems = append(problems, ValidationError{Path: FormatPath([]string{device}), Message: fmt.Sprintf(format, args...)})
+	}
+	profiles := map[string]*OTDProfile{}
+	for _, device := range devices {
+		tablet, kind := otdTabletName(device.Name)
+		profile, ok := profiles[tablet]
+		if !ok {
+			profile = &OTDProfile{
+				Tablet:               tablet,
+				OutputMode:           OTDPlugin{Path: OTD_ABSOLUTE_MODE, Settings: []OTDSetting{}, Enable: true},
+				AbsoluteModeSettings: OTDAbsoluteSettings{EnableClipping: true},
+				Bindings: OTDBindings{
+					TipButton:  &OTDPlugin{Path: OTD_MOUSE_BINDING, Settings: []OTDSetting{{"Button", "Left"}}, Enable: true},
+					PenButtons: []*OTDPlugin{},
+					AuxButtons: []*OTDPlugin{},
+				},
+			}
+			profiles[tablet] = profile
+		}
+		switch kind {
+		case "stylus":
+			exportStylus(profile, device, unitsPerMM, func(format string, args ...any) { report(device.Name, format, args...) })
+		case "pad":
+			for _, button := range sortedKeys(device.Buttons) {
+				number, _ := strconv.Atoi(button)
+				aux, ok := otdAuxButton(number)
+				if !ok {
+					report(device.Name, "pad button %s scrolls, OpenTabletDriver has no binding for it", button)
+					continue
+				}
+				binding, err := otdBinding(device.Buttons[button])
+				if err != nil {
+					report(device.Name, "button %s: %s", button, err.Error())
+					continue
+				}
+				setBinding(&profile.Bindings.AuxButtons, aux, binding)
+			}
+		default:
+			if len(device.Buttons) > 0 || device.Display != nil {
+				report(device.Name, "only the stylus and pad of a tablet are exported")
+			}
+		}
+	}
+
+	settings := OTDSettings{Profiles: make([]OTDProfile, 0, len(profiles))}
+	for _, tablet := range sortedKeys(profiles) {
+		settings.Profiles = append(settings.Profiles, *profiles[tablet])
+	}
+	return settings, problems
+}
+
+func exportStylus(profile *OTDProfile, device OTDDevice, unitsPerMM float64, report func(format string, args ...any)) {
+	if device.Mode == inputs.INPUT_MODE_RELATIVE {
+		profile.OutputMode.Path = OTD_RELATIVE_MODE
+	}
+	if rect := device.Display; rect != nil {
+		profile.AbsoluteModeSettings.Display = &OTDArea{
+			Width:  float64(rect.Width),
+			Height: float64(rect.Height),
+			X:      float64(rect.X) + float64(rect.Width)/2,
+			Y:      float64(rect.Y) + float64(rect.Height)/2,
+		}
+	}
+	switch area := device.TabletArea; {
+	case len(area) == 4 && unitsPerMM > 0:
+		profile.AbsoluteModeSettings.Tablet = &OTDArea{
+			Width:    float64(area[2]-area[0]) / unitsPerMM,
+			Height:   float64(area[3]-area[1]) / unitsPerMM,
+			X:        float64(area[0]+area[2]) / 2 / unitsPerMM,
+			Y:        float64(area[1]+area[3]) / 2 / unitsPerMM,
+			Rotation: float64(device.Rotation),
+		}
+	case len(area) == 4:
+		report("the tablet area is left out, it needs the tablet units per millimeter")
+	case device.Rotation != 0:
+		report("the rotation by %d is left out, it is set on the tablet area which isn't known", device.Rotation)
+	}
+	for _, button := range sortedKeys(device.Buttons) {
+		binding, err := otdBinding(device.Buttons[button])
+		if err != nil {
+			report("button %s: %s", button, err.Error())
+			continue
+		}
+		switch number, _ := strconv.Atoi(button); {
+		case number == 1:
+			profile.Bindings.TipButton = binding
+		case number == 2 || number == 3:
+			setBinding(&profile.Bindings.PenButtons, number-2, binding)
+		default:
+			report("button %s isn't a pen button", button)
+		}
+	}
+}
+
+// ImportOpenTabletDriver converts the profiles of an OpenTabletDriver
+// settings.json to device configs. The device names are taken from the
+// connected inputs whose name starts with the tablet name. The display
+// area is stored as the desktop or an output of layout if it covers one
+// exactly, and as a region otherwise. Tablet areas are converted with
+// unitsPerMM tablet units per millimeter and left out if it is 0. Settings
+// which can't be converted are returned as problems.
+func ImportOpenTabletDriver(file string, buf []byte, layout outputs.Layout, connected []inputs.Input, unitsPerMM float64) (TabletMapperConfig, []ValidationError, error) {
+	settings := OTDSettings{}
+	if err := json.Unmarshal(buf, &settings); err != nil {
+		return nil, nil, fmt.Errorf("Couldn't read OpenTabletDriver settings %w", describeJSONError(file, buf, err))
+	}
+	devices := TabletMapperConfig{}
+	problems := make([]ValidationError, 0)
+	for i, profile := range settings.Profiles {
+		path := []string{"Profiles", strconv.Itoa(i)}
+		report := func(keys []string, format string, args ...any) {
+			problems = append(problems, ValidationError{File: file, Path: FormatPath(join(path, keys...)), Message: fmt.Sprintf(format, args...)})
+		}
+		stylus := inputs.InputConfig{}
+		switch {
+		case strings.HasSuffix(profile.OutputMode.Path, ".RelativeMode"):
+			stylus.Mode = inputs.INPUT_MODE_RELATIVE
+		case strings.HasSuffix(profile.OutputMode.Path, "Mode"):
+			stylus.Mode = inputs.INPUT_MODE_ABSOLUTE
+			importDisplay(&stylus, profile.AbsoluteModeSettings.Display, layout, func(format string, args ...any) {
+				report([]string{"AbsoluteModeSettings", "Display"}, format, args...)
+			})
+		default:
+			report([]string{"OutputMode"}, "unknown output mode '%s'", profile.OutputMode.Path)
+		}
+		if area := profile.AbsoluteModeSettings.Tablet; area != nil {
+			importTablet(&stylus, *area, unitsPerMM, func(format string, args ...any) {
+				report([]string{"AbsoluteModeSettings", "Tablet"}, format, args...)
+			})
+		}
+
+		stylusButtons := map[int]*OTDPlugin{1: profile.Bindings.TipButton}
+		for i, binding := range profile.Bindings.PenButtons {
+			stylusButtons[i+2] = binding
+		}
+		padButtons := map[int]*OTDPlugin{}
+		for i, binding := range profile.Bindings.AuxButtons {
+			padButtons[padButton(i)] = binding
+		}
+		pad := inputs.InputConfig{}
+		for _, device := range []struct {
+			config   *inputs.InputConfig
+			bindings map[int]*OTDPlugin
+			keys     func(button int) []string
+		}{
+			{&stylus, stylusButtons, func(button int) []string {
+				if button == 1 {
+					return []string{"Bindings", "TipButton"}
+				}
+				return []string{"Bindings", "PenButtons", strconv.Itoa(button - 2)}
+			}},
+			{&pad, padButtons, func(button int) []string {
+				aux, _ := otdAuxButton(button)
+				return []string{"Bindings", "AuxButtons", strconv.Itoa(aux)}
+			}},
+		} {
+			for button, binding := range device.bindings {
+				if binding == nil || !binding.Enable {
+					continue
+				}
+				action, err := buttonAction(*binding)
+				if err != nil {
+					report(device.keys(button), "%s", err.Error())
+					continue
+				}
+				if clicked, ok := inputs.ClickedButton(action); ok && clicked == button {
+					continue
+				}
+				if device.config.Buttons == nil {
+					device.config.Buttons = map[string]string{}
+				}
+				device.config.Buttons[strconv.Itoa(button)] = action
+			}
+		}
+
+		devices[otdDeviceName(profile.Tablet, "stylus", connected)] = stylus
+		if len(pad.Buttons) > 0 {
+			devices[otdDeviceName(profile.Tablet, "pad", connected)] = pad
+		}
+	}
+	sort.SliceStable(problems, func(i, j int) bool { return problems[i].Path < problems[j].Path })
+	return devices, problems, nil
+}
+
+func importDisplay(config *inputs.InputConfig, area *OTDArea, layout outputs.Layout, report func(format string, args ...any)) {
+	if area == nil {
+		return
+	}
+	if area.Width <= 0 || area.Height <= 0 {
+		report("invalid display area %gx%g", area.Width, area.Height)
+		return
+	}
+	rect := outputs.Rect{
+		X:      int(math.Round(area.X - area.Width/2)),
+		Y:      int(math.Round(area.Y - area.Height/2)),
+		Width:  int(math.Round(area.Width)),
+		Height: int(math.Round(area.Height)),
+	}
+	if layout.Width > 0 && rect == (outputs.Rect{Width: layout.Width, Height: layout.Height}) {
+		config.MappingType = inputs.INPUT_MAPPING_DESKTOP
+		return
+	}
+	for _, output := range layout.Outputs {
+		if output.Rect == rect {
+			config.MappingType, config.OutputName = inputs.INPUT_MAPPING_OUTPUT, output.Name
+			return
+		}
+	}
+	config.MappingType, config.Region = inputs.INPUT_MAPPING_REGION, rect.Geometry()
+}
+
+func importTablet(config *inputs.InputConfig, area OTDArea, unitsPerMM float64, report func(format string, args ...any)) {
+	if rotation := int(area.Rotation); float64(rotation) != area.Rotation || rotation%90 != 0 {
+		report("rotation by %g degrees is left out, only multiples of 90 are supported", area.Rotation)
+	} else {
+		config.Rotation = (rotation%360 + 360) % 360
+	}
+	if unitsPerMM <= 0 {
+		report("the tablet area is left out, it needs the tablet units per millimeter")
+		return
+	}
+	units := func(mm float64) int {
+		return int(math.Round(mm * unitsPerMM))
+	}
+	config.TabletArea = []int{
+		max(units(area.X-area.Width/2), 0),
+		max(units(area.Y-area.Height/2), 0),
+		units(area.X + area.Width/2),
+		units(area.Y + area.Height/2),
+	}
+}
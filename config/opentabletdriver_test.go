@@ -0,0 +1,135 @@
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"tablet_mapper/inputs"
+	"tablet_mapper/outputs"
+	"testing"
+)
+
+func TestOpenTabletDriverRoundTrip(t *testing.T) {
+	layout := outputs.Layout{Width: 4480, Height: 1440, Outputs: []outputs.Output{
+		{Name: "DP-2", Rect: outputs.Rect{Width: 2560, Height: 1440}},
+		{Name: "HDMI-1", Primary: true, Rect: outputs.Rect{X: 2560, Width: 1920, Height: 1080}},
+	}}
+	connected := []inputs.Input{{Id: 12, Name: "HUION H420 Pen stylus"}, {Id: 13, Name: "HUION H420 Pad pad"}}
+	tests := []struct {
+		name    string
+		devices []OTDDevice
+		want    TabletMapperConfig
+	}{
+		{
+			"output and buttons",
+			[]OTDDevice{
+				{
+					Name:       "HUION H420 Pen stylus",
+					Display:    &layout.Outputs[1].Rect,
+					Rotation:   180,
+					Mode:       inputs.INPUT_MODE_ABSOLUTE,
+					TabletArea: []int{0, 0, 16000, 10000},
+					Buttons:    map[string]string{"2": "key +ctrl +z -z -ctrl", "3": "button +9"},
+				},
+				{
+					Name:    "HUION H420 Pad pad",
+					Buttons: map[string]string{"1": "key e", "2": "key +ctrl +shift +s -s -shift -ctrl", "8": "key F5"},
+				},
+			},
+			TabletMapperConfig{
+				"HUION H420 Pen stylus": {
+					MappingType: inputs.INPUT_MAPPING_OUTPUT,
+					OutputName:  "HDMI-1",
+					Rotation:    180,
+					Mode:        inputs.INPUT_MODE_ABSOLUTE,
+					TabletArea:  []int{0, 0, 16000, 10000},
+					Buttons:     map[string]string{"2": "key +ctrl +z -z -ctrl", "3": "button +9"},
+				},
+				"HUION H420 Pad pad": {
+					Buttons: map[string]string{"1": "key +e -e", "2": "key +ctrl +shift +s -s -shift -ctrl", "8": "key +F5 -F5"},
+				},
+			},
+		},
+		{
+			"desktop",
+			[]OTDDevice{{Name: "HUION H420 Pen stylus", Display: &outputs.Rect{Width: 4480, Height: 1440}, Mode: inputs.INPUT_MODE_ABSOLUTE}},
+			TabletMapperConfig{"HUION H420 Pen stylus": {MappingType: inputs.INPUT_MAPPING_DESKTOP, Mode: inputs.INPUT_MODE_ABSOLUTE}},
+		},
+		{
+			"region",
+			[]OTDDevice{{Name: "HUION H420 Pen stylus", Display: &outputs.Rect{X: 100, Y: 50, Width: 800, Height: 600}, Mode: inputs.INPUT_MODE_ABSOLUTE}},
+			TabletMapperConfig{"HUION H420 Pen stylus": {MappingType: inputs.INPUT_MAPPING_REGION, Region: "800x600+100+50", Mode: inputs.INPUT_MODE_ABSOLUTE}},
+		},
+		{
+			"relative",
+			[]OTDDevice{{Name: "HUION H420 Pen stylus", Mode: inputs.INPUT_MODE_RELATIVE}},
+			TabletMapperConfig{"HUION H420 Pen stylus": {Mode: inputs.INPUT_MODE_RELATIVE}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			settings, problems := ExportOpenTabletDriver(test.devices, 100)
+			if len(problems) > 0 {
+				t.Fatalf("ExportOpenTabletDriver problems: %q", problemStrings(problems))
+			}
+			buf, err := json.Marshal(settings)
+			if err != nil {
+				t.Fatal(err)
+			}
+			devices, problems, err := ImportOpenTabletDriver("settings.json", buf, layout, connected, 100)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(problems) > 0 {
+				t.Fatalf("ImportOpenTabletDriver problems: %q", problemStrings(problems))
+			}
+			if !sameJSON(devices, test.want) {
+				got, _ := json.Marshal(devices)
+				want, _ := json.Marshal(test.want)
+				t.Errorf("round trip =\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestExportOpenTabletDriverProblems(t *testing.T) {
+	devices := []OTDDevice{
+		{Name: "HUION H420 Pen stylus", TabletArea: []int{0, 0, 100, 100}, Buttons: map[string]string{"2": "key a b", "5": "button +1"}},
+		{Name: "HUION H420 Pad pad", Buttons: map[string]string{"4": "button +4", "1": "modetoggle"}},
+	}
+	_, problems := ExportOpenTabletDriver(devices, 0)
+	want := []string{
+		`"HUION H420 Pen stylus": the tablet area is left out, it needs the tablet units per millimeter`,
+		`"HUION H420 Pen stylus": button 2: 'key a b' types several keys, OpenTabletDriver only binds one combination`,
+		`"HUION H420 Pen stylus": button 5 isn't a pen button`,
+		`"HUION H420 Pad pad": button 1: 'modetoggle' has no OpenTabletDriver binding, only clicks and key combinations do`,
+		`"HUION H420 Pad pad": pad button 4 scrolls, OpenTabletDriver has no binding for it`,
+	}
+	if got := problemStrings(problems); !reflect.DeepEqual(got, want) {
+		t.Errorf("ExportOpenTabletDriver problems =\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestOTDKey(t *testing.T) {
+	tests := []struct {
+		key    string
+		want   string
+		wantOk bool
+	}{
+		{"ctrl", "Control", true},
+		{"Control_R", "Control", true},
+		{"SHIFT", "Shift", true},
+		{"Prior", "PageUp", true},
+		{"Esc", "Escape", true},
+		{"z", "Z", true},
+		{"5", "D5", true},
+		{"F12", "F12", true},
+		{"hyper", "", false},
+		{"XF86AudioMute", "", false},
+	}
+	for _, test := range tests {
+		got, ok := otdKey(test.key)
+		if got != test.want || ok != test.wantOk {
+			t.Errorf("otdKey(%q) = %q, %v, want %q, %v", test.key, got, ok, test.want, test.wantOk)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"tablet_mapper/outputs"
+)
+
+const DEFAULT_PROFILE = "default"
+
+// Profile is a named set of device configs, e.g. one for the laptop screen
+// and one for the docked setup.
+type Profile struct {
+	Devices TabletMapperConfig `json:"devices"`
+	Layout  *MonitorLayout     `json:"layout,omitempty"`
+}
+
+// GetProfile returns the named profile, or the active profile if name is
+// empty, along with its name.
+func (doc Document) GetProfile(name string) (string, Profile, error) {
+	if name == "" {
+		name = doc.ActiveProfile
+	}
+	profile, ok := doc.Profiles[name]
+	if !ok {
+		return name, Profile{}, fmt.Errorf("No profile named '%s'", name)
+	}
+	return name, profile, nil
+}
+
+func (doc Document) ProfileNames() []string {
+	names := make([]string, 0, len(doc.Profiles))
+	for name := range doc.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DeleteProfile removes a profile. The active profile can't be deleted.
+func (doc Document) DeleteProfile(name string) error {
+	if _, ok := doc.Profiles[name]; !ok {
+		return fmt.Errorf("No profile named '%s'", name)
+	}
+	if name == doc.ActiveProfile {
+		return fmt.Errorf("Profile '%s' is active, activate another profile before deleting it", name)
+	}
+	delete(doc.Profiles, name)
+	return nil
+}
+
+// MonitorLayout is the set of monitors a profile is meant for. The profile
+// is picked automatically when exactly these outputs are active.
+type MonitorLayout struct {
+	Outputs []MonitorSpec `json:"outputs"`
+}
+
+// MonitorSpec matches an active output. Empty fields match any output.
+type MonitorSpec struct {
+	Name       string `json:"name,omitempty"`
+	EDID       string `json:"edid,omitempty"`
+	Resolution string `json:"resolution,omitempty"`
+}
+
+func MonitorLayoutOf(layout outputs.Layout) MonitorLayout {
+	monitors := MonitorLayout{Outputs: make([]MonitorSpec, 0, len(layout.Outputs))}
+	for _, output := range layout.Outputs {
+		monitors.Outputs = append(monitors.Outputs, MonitorSpec{
+			Name:       output.Name,
+			EDID:       output.EDID,
+			Resolution: output.Resolution(),
+		})
+	}
+	return monitors
+}
+
+func (spec MonitorSpec) Matches(output outputs.Output) bool {
+	return (spec.Name == "" || spec.Name == output.Name) &&
+		(spec.EDID == "" || spec.EDID == output.EDID) &&
+		(spec.Resolution == "" || spec.Resolution == output.Resolution())
+}
+
+// Matches reports whether every active output is matched by a different
+// monitor of the layout. Specs are assigned with augmenting paths, so a
+// wildcard spec never blocks an output a more specific spec needs.
+func (monitors MonitorLayout) Matches(layout outputs.Layout) bool {
+	if len(monitors.Outputs) != len(layout.Outputs) {
+		return false
+	}
+	// specOf holds the index of the spec each output is assigned to, or -1.
+	specOf := make([]int, len(layout.Outputs))
+	for i := range specOf {
+		specOf[i] = -1
+	}
+	var assign func(spec int, visited []bool) bool
+	assign = func(spec int, visited []bool) bool {
+		for i, output := range layout.Outputs {
+			if visited[i] || !monitors.Outputs[spec].Matches(output) {
+				continue
+			}
+			visited[i] = true
+			if specOf[i] < 0 || assign(specOf[i], visited) {
+				specOf[i] = spec
+				return true
+			}
+		}
+		return false
+	}
+	for spec := range monitors.Outputs {
+		if !assign(spec, make([]bool, len(layout.Outputs))) {
+			return false
+		}
+	}
+	return true
+}
+
+// ProfileForLayout returns the first profile, in name order, declaring a
+// monitor layout matching layout.
+func (doc Document) ProfileForLayout(layout outputs.Layout) (string, bool) {
+	for _, name := range doc.ProfileNames() {
+		monitors := doc.Profiles[name].Layout
+		if monitors != nil && monitors.Matches(layout) {
+			return name, true
+		}
+	}
+	return "", false
+}
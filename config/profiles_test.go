@@ -0,0 +1,33 @@
+package config
+
+import (
+	"tablet_mapper/outputs"
+	"testing"
+)
+
+func TestMonitorLayoutMatches(t *testing.T) {
+	layout := outputs.Layout{Outputs: []outputs.Output{
+		{Name: "DP-2", EDID: "aaaa", Rect: outputs.Rect{Width: 2560, Height: 1440}},
+		{Name: "HDMI-1", EDID: "bbbb", Rect: outputs.Rect{X: 2560, Width: 1920, Height: 1080}},
+	}}
+	tests := []struct {
+		name  string
+		specs []MonitorSpec
+		want  bool
+	}{
+		{"exact", []MonitorSpec{{Name: "DP-2"}, {Name: "HDMI-1"}}, true},
+		{"any order", []MonitorSpec{{Name: "HDMI-1"}, {EDID: "aaaa"}}, true},
+		{"wildcard before specific", []MonitorSpec{{}, {Name: "DP-2"}}, true},
+		{"resolution wildcard before specific", []MonitorSpec{{Resolution: "2560x1440"}, {}}, true},
+		{"too few specs", []MonitorSpec{{}}, false},
+		{"unknown output", []MonitorSpec{{Name: "DP-2"}, {Name: "eDP-1"}}, false},
+		{"two specs for one output", []MonitorSpec{{Name: "DP-2"}, {EDID: "aaaa"}}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := (MonitorLayout{Outputs: test.specs}).Matches(layout); got != test.want {
+				t.Errorf("Matches(%+v) = %v, want %v", test.specs, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,204 @@
+package config
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+// tomlComments are the comments of a TOML file by the path of the table
+// header or key they are above or behind. Comments inside values spanning
+// several lines aren't kept.
+type tomlComments struct {
+	head map[string][]string
+	line map[string]string
+	// top and foot hold the comments at the start and the end of the file.
+	top  []string
+	foot []string
+}
+
+// readTOMLComments collects the comments of a TOML file.
+func readTOMLComments(buf []byte) tomlComments {
+	comments := tomlComments{head: map[string][]string{}, line: map[string]string{}}
+	pending := make([]string, 0)
+	walkTOML(buf, func(line string, path string, comment string) {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case path != "" && comments.top == nil:
+			comments.top = pending
+			pending = make([]string, 0)
+			fallthrough
+		case path != "":
+			if len(pending) > 0 {
+				comments.head[path] = pending
+				pending = make([]string, 0)
+			}
+			if comment != "" {
+				comments.line[path] = comment
+			}
+		case strings.HasPrefix(trimmed, "#"):
+			pending = append(pending, trimmed)
+		}
+	})
+	comments.foot = pending
+	return comments
+}
+
+// apply adds the comments to buf, a TOML file written by the encoder.
+func (comments tomlComments) apply(buf []byte) []byte {
+	out := bytes.Buffer{}
+	for _, top := range comments.top {
+		out.WriteString(top + "\n")
+	}
+	walkTOML(buf, func(line string, path string, comment string) {
+		if path != "" {
+			for _, head := range comments.head[path] {
+				out.WriteString(head + "\n")
+			}
+			if c, ok := comments.line[path]; ok && comment == "" {
+				line += " " + c
+			}
+		}
+		out.WriteString(line + "\n")
+	})
+	for _, foot := range comments.foot {
+		out.WriteString(foot + "\n")
+	}
+	return out.Bytes()
+}
+
+// walkTOML calls visit for every line of buf with the path of the table
+// header or key on it, formatted like FormatPath, and the comment behind
+// it. path is empty for blank lines, comments and lines continuing a value.
+func walkTOML(buf []byte, visit func(line string, path string, comment string)) {
+	table := []string{}
+	arrays := map[string]int{}
+	depth, open := 0, ""
+	lines := strings.Split(strings.TrimSuffix(string(buf), "\n"), "\n")
+	if len(buf) == 0 {
+		lines = nil
+	}
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if open != "" || depth > 0 {
+			value, closed := line, false
+			if open != "" {
+				if _, rest, ok := strings.Cut(line, open); ok {
+					value, open, closed = rest, "", true
+				}
+			}
+			if open == "" && (closed || depth > 0) {
+				d, o, _ := scanTOMLValue(value)
+				depth, open = depth+d, o
+			}
+			visit(line, "", "")
+			continue
+		}
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			visit(line, "", "")
+			continue
+		}
+		if strings.HasPrefix(trimmed, "[") {
+			isArray := strings.HasPrefix(trimmed, "[[")
+			keys, rest := parseTOMLKey(strings.TrimLeft(trimmed, "["))
+			rest = strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(rest), "]"))
+			table = resolveTOMLTable(keys, isArray, arrays)
+			comment := ""
+			if strings.HasPrefix(rest, "#") {
+				comment = rest
+			}
+			visit(strings.TrimRight(line, " \t"), FormatPath(table), comment)
+			continue
+		}
+		keys, rest := parseTOMLKey(trimmed)
+		value, _ := strings.CutPrefix(strings.TrimSpace(rest), "=")
+		d, o, comment := scanTOMLValue(value)
+		depth, open = d, o
+		visit(line, FormatPath(append(table[:len(table):len(table)], keys...)), comment)
+	}
+}
+
+// resolveTOMLTable returns the path of a table header, with the index of
+// the current element of the arrays of tables it is in.
+func resolveTOMLTable(keys []string, isArray bool, arrays map[string]int) []string {
+	resolved := make([]string, 0, len(keys)+1)
+	for i, key := range keys {
+		resolved = append(resolved, key)
+		path := FormatPath(resolved)
+		if isArray && i == len(keys)-1 {
+			resolved = append(resolved, strconv.Itoa(arrays[path]))
+			arrays[path]++
+		} else if n, ok := arrays[path]; ok {
+			resolved = append(resolved, strconv.Itoa(n-1))
+		}
+	}
+	return resolved
+}
+
+// parseTOMLKey reads a dotted key made of bare and quoted keys from the
+// start of s.
+func parseTOMLKey(s string) ([]string, string) {
+	keys := make([]string, 0)
+	for {
+		s = strings.TrimLeft(s, " \t")
+		key := ""
+		switch {
+		case strings.HasPrefix(s, `"`):
+			quoted, err := strconv.QuotedPrefix(s)
+			if err != nil {
+				return keys, s
+			}
+			key, _ = strconv.Unquote(quoted)
+			s = s[len(quoted):]
+		case strings.HasPrefix(s, "'"):
+			end := strings.Index(s[1:], "'")
+			if end < 0 {
+				return keys, s
+			}
+			key, s = s[1:end+1], s[end+2:]
+		default:
+			end := strings.IndexAny(s, " \t.=]")
+			if end < 0 {
+				end = len(s)
+			}
+			key, s = s[:end], s[end:]
+		}
+		keys = append(keys, key)
+		s = strings.TrimLeft(s, " \t")
+		if !strings.HasPrefix(s, ".") {
+			return keys, s
+		}
+		s = s[1:]
+	}
+}
+
+// scanTOMLValue returns how many arrays and inline tables a value leaves
+// open at the end of the line, the delimiter of a multi-line string it
+// leaves open and the comment behind it.
+func scanTOMLValue(s string) (depth int, open string, comment string) {
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '#':
+			return depth, "", strings.TrimSpace(s[i:])
+		case '[', '{':
+			depth++
+		case ']', '}':
+			depth--
+		case '"', '\'':
+			if delimiter := strings.Repeat(string(c), 3); strings.HasPrefix(s[i:], delimiter) {
+				end := strings.Index(s[i+3:], delimiter)
+				if end < 0 {
+					return depth, delimiter, ""
+				}
+				i += end + 5
+				continue
+			}
+			for i++; i < len(s) && s[i] != c; i++ {
+				if c == '"' && s[i] == '\\' {
+					i++
+				}
+			}
+		}
+	}
+	return depth, "", ""
+}
@@ -0,0 +1,105 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEncodeDocumentKeepsTOMLComments(t *testing.T) {
+	previous := `# tablet setup
+version = 3
+activeProfile = "default" # the docked one
+
+[profiles.default.devices."HUION Pad pad"]
+mappingType = "desktop"
+rotation = 0
+buttons.1 = "key +ctrl +z -z -ctrl" # undo
+coordMatrix = [
+  [1, 0, 0], # comments inside values are lost
+  [0, 1, 0],
+  [0, 0, 1],
+]
+
+# follows krita
+[[profiles.default.devices."HUION Pad pad".rules]]
+mappingType = "window"
+rotation = 0
+[profiles.default.devices."HUION Pad pad".rules.match]
+class = "krita" # WM_CLASS
+
+[[profiles.default.devices."HUION Pad pad".rules]]
+mappingType = "desktop"
+rotation = 0
+[profiles.default.devices."HUION Pad pad".rules.match]
+class = "gimp" # second rule
+# the end
+`
+	layerDoc, _, err := decodeFile("config.toml", FORMAT_TOML, []byte(previous))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, _, err := ParseDocument(layerDoc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	device := doc.Profiles["default"].Devices["HUION Pad pad"]
+	device.Rotation = 90
+	doc.Profiles["default"].Devices["HUION Pad pad"] = device
+
+	buf, err := EncodeDocument(doc, FORMAT_TOML, []byte(previous))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded := string(buf)
+	for _, want := range []string{
+		"# tablet setup\n",
+		`activeProfile = "default" # the docked one` + "\n",
+		`1 = "key +ctrl +z -z -ctrl" # undo` + "\n",
+		"# follows krita\n[[profiles.default.devices.\"HUION Pad pad\".rules]]\n",
+		`class = "krita" # WM_CLASS` + "\n",
+		`class = "gimp" # second rule` + "\n",
+		"rotation = 90\n",
+		"# the end\n",
+	} {
+		if !strings.Contains(encoded, want) {
+			t.Errorf("encoded TOML is missing %q:\n%s", want, encoded)
+		}
+	}
+	if strings.Contains(encoded, "inside values") {
+		t.Errorf("kept a comment from inside a value:\n%s", encoded)
+	}
+	if _, _, err := decodeFile("config.toml", FORMAT_TOML, buf); err != nil {
+		t.Errorf("encoded TOML doesn't decode: %v\n%s", err, encoded)
+	}
+}
+
+func TestWalkTOMLPaths(t *testing.T) {
+	file := `a = 1
+b.c = "x # not a comment"
+s = """
+d = 2
+"""
+[t]
+'e f' = [
+  1,
+]
+[[t.list]]
+g = 3
+[t.list.sub]
+h = 4
+[[t.list]]
+[t.list.sub]
+i = 5
+`
+	want := []string{"a", "b.c", "s", "", "", "t", `t."e f"`, "", "", "t.list.0", "t.list.0.g", "t.list.0.sub", "t.list.0.sub.h", "t.list.1", "t.list.1.sub", "t.list.1.sub.i"}
+	got := make([]string, 0)
+	walkTOML([]byte(file), func(line string, path string, comment string) {
+		got = append(got, path)
+		if comment != "" {
+			t.Errorf("unexpected comment %q on line %q", comment, line)
+		}
+	})
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("walkTOML paths = %q, want %q", got, want)
+	}
+}
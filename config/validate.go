@@ -0,0 +1,371 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"tablet_mapper/inputs"
+	"tablet_mapper/outputs"
+)
+
+var validRotations = []int{0, 90, 180, 270}
+
+var validMappingTypes = []inputs.InputMappingType{
+	inputs.INPUT_MAPPING_COORD_MATRIX,
+	inputs.INPUT_MAPPING_WINDOW,
+	inputs.INPUT_MAPPING_DESKTOP,
+	inputs.INPUT_MAPPING_OUTPUT,
+	inputs.INPUT_MAPPING_REGION,
+}
+
+var validClipModes = []inputs.InputClipMode{
+	inputs.INPUT_CLIP_NONE,
+	inputs.INPUT_CLIP_SCREEN,
+	inputs.INPUT_CLIP_MONITOR,
+}
+
+// ValidationError is a problem with a config value. File and Line are
+// empty when the value doesn't come from a file.
+type ValidationError struct {
+	File    string
+	Line    int
+	Path    string
+	Message string
+}
+
+func (e ValidationError) Error() string {
+	location := ""
+	if e.File != "" {
+		location = e.File + ":"
+		if e.Line > 0 {
+			location += strconv.Itoa(e.Line) + ":"
+		}
+		location += " "
+	}
+	if e.Path != "" {
+		location += e.Path + ": "
+	}
+	return location + e.Message
+}
+
+func lineOf(buf []byte, offset int64) int {
+	offset = min(max(offset, 0), int64(len(buf)))
+	return bytes.Count(buf[:offset], []byte("\n")) + 1
+}
+
+// describeJSONError adds the file and line to syntax and type errors.
+func describeJSONError(file string, buf []byte, err error) error {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		return ValidationError{File: file, Line: lineOf(buf, syntaxErr.Offset), Message: syntaxErr.Error()}
+	case errors.As(err, &typeErr):
+		return ValidationError{
+			File:    file,
+			Line:    lineOf(buf, typeErr.Offset),
+			Path:    typeErr.Field,
+			Message: fmt.Sprintf("expected %s, got %s", typeErr.Type, typeErr.Value),
+		}
+	}
+	return fmt.Errorf("%s: %w", file, err)
+}
+
+// jsonLineIndex maps the path of every key and array element of the JSON
+// document in buf to its line.
+func jsonLineIndex(buf []byte) map[string]int {
+	lines := map[string]int{}
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	var walk func(path []string) error
+	walk = func(path []string) error {
+		token, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		if _, ok := lines[FormatPath(path)]; !ok && len(path) > 0 {
+			lines[FormatPath(path)] = lineOf(buf, dec.InputOffset())
+		}
+		switch token {
+		case json.Delim('{'):
+			for dec.More() {
+				key, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				child := append(path[:len(path):len(path)], key.(string))
+				lines[FormatPath(child)] = lineOf(buf, dec.InputOffset())
+				if err = walk(child); err != nil {
+					return err
+				}
+			}
+			_, err = dec.Token()
+		case json.Delim('['):
+			for i := 0; dec.More(); i++ {
+				if err = walk(append(path[:len(path):len(path)], strconv.Itoa(i))); err != nil {
+					return err
+				}
+			}
+			_, err = dec.Token()
+		}
+		return err
+	}
+	walk(nil)
+	return lines
+}
+
+// Locate returns the file and line a value at path was read from. Values
+// inside arrays and objects are looked up through the layer of their
+// parent or first child.
+func (origins Origins) Locate(path string) (string, int) {
+	if origin, ok := origins[path]; ok {
+		return origin.Layer, origin.Line
+	}
+	for parent := path; parent != ""; {
+		idx := strings.LastIndex(parent, ".")
+		if idx < 0 {
+			break
+		}
+		parent = parent[:idx]
+		if origin, ok := origins[parent]; ok {
+			return origin.Layer, origin.lines[path]
+		}
+	}
+	children := make([]string, 0)
+	for p := range origins {
+		if strings.HasPrefix(p, path+".") {
+			children = append(children, p)
+		}
+	}
+	if len(children) == 0 {
+		return "", 0
+	}
+	sort.Strings(children)
+	origin := origins[children[0]]
+	return origin.Layer, origin.lines[path]
+}
+
+// knownPath reports whether path names a field of Document.
+func knownPath(path []string) bool {
+	_, _, err := pathType(reflect.TypeOf(Document{}), path)
+	return err == nil
+}
+
+// pathType returns the type of the value at path in a value of type t,
+// following the keys used by encoding/json. It also returns the path with
+// the keys of struct fields spelled like in their JSON tags.
+func pathType(t reflect.Type, path []string) (reflect.Type, []string, error) {
+	canonical := make([]string, 0, len(path))
+	for i, key := range path {
+		for t.Kind() == reflect.Pointer {
+			t = t.Elem()
+		}
+		switch t.Kind() {
+		case reflect.Map:
+			t = t.Elem()
+		case reflect.Slice, reflect.Array:
+			index, err := strconv.Atoi(key)
+			if err != nil || index < 0 || (t.Kind() == reflect.Array && index >= t.Len()) {
+				return nil, nil, fmt.Errorf("Invalid list index '%s' in %s", key, FormatPath(path[:i+1]))
+			}
+			t = t.Elem()
+		case reflect.Struct:
+			field, name, ok := jsonField(t, key)
+			if !ok {
+				return nil, nil, fmt.Errorf("Unknown field %s", FormatPath(path[:i+1]))
+			}
+			t, key = field, name
+		default:
+			return nil, nil, fmt.Errorf("%s has no fields", FormatPath(path[:i]))
+		}
+		canonical = append(canonical, key)
+	}
+	return t, canonical, nil
+}
+
+// jsonField finds the type and key of the field encoded as key, including
+// the fields of embedded structs. Like encoding/json it ignores case.
+func jsonField(t reflect.Type, key string) (reflect.Type, string, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if field.Anonymous && name == "" {
+			if embedded, embeddedName, ok := jsonField(field.Type, key); ok {
+				return embedded, embeddedName, true
+			}
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		if strings.EqualFold(name, key) {
+			return field.Type, name, true
+		}
+	}
+	return nil, "", false
+}
+
+type validator struct {
+	origins Origins
+	errors  []ValidationError
+}
+
+func (v *validator) add(path []string, format string, args ...any) {
+	e := ValidationError{Path: FormatPath(path), Message: fmt.Sprintf(format, args...)}
+	e.File, e.Line = v.origins.Locate(e.Path)
+	v.errors = append(v.errors, e)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func join(path []string, keys ...string) []string {
+	return append(path[:len(path):len(path)], keys...)
+}
+
+// Validate checks the values of doc, e.g. that rotations, mapping types and
+// button actions are valid. origins, which may be nil, are used to find the
+// file and line of each problem. Keys in origins that aren't part of the
+// document are reported as unknown.
+func Validate(doc Document, origins Origins) []ValidationError {
+	v := &validator{origins: origins}
+	for _, path := range sortedKeys(origins) {
+		if !knownPath(origins[path].path) {
+			v.add(origins[path].path, "unknown field")
+		}
+	}
+	if _, ok := doc.Profiles[doc.ActiveProfile]; !ok {
+		v.add([]string{"activeProfile"}, "no profile named '%s'", doc.ActiveProfile)
+	}
+	for _, name := range sortedKeys(doc.Profiles) {
+		profile := doc.Profiles[name]
+		path := []string{"profiles", name}
+		for _, device := range sortedKeys(profile.Devices) {
+			v.inputConfig(join(path, "devices", device), profile.Devices[device])
+		}
+		if profile.Layout != nil {
+			for i, spec := range profile.Layout.Outputs {
+				if spec.Resolution == "" {
+					continue
+				}
+				var width, height int
+				if n, _ := fmt.Sscanf(spec.Resolution, "%dx%d", &width, &height); n != 2 || width <= 0 || height <= 0 {
+					v.add(join(path, "layout", "outputs", strconv.Itoa(i), "resolution"), "invalid resolution '%s', expected WIDTHxHEIGHT", spec.Resolution)
+				}
+			}
+		}
+	}
+	sort.SliceStable(v.errors, func(i, j int) bool {
+		a, b := v.errors[i], v.errors[j]
+		return a.File < b.File || a.File == b.File && a.Line < b.Line
+	})
+	return v.errors
+}
+
+func (v *validator) inputConfig(path []string, config inputs.InputConfig) {
+	if config.Mode != "" && config.Mode != inputs.INPUT_MODE_ABSOLUTE && config.Mode != inputs.INPUT_MODE_RELATIVE {
+		v.add(join(path, "mode"), "unknown mode '%s', expected %s or %s", config.Mode, inputs.INPUT_MODE_ABSOLUTE, inputs.INPUT_MODE_RELATIVE)
+	}
+	if area := config.TabletArea; len(area) > 0 {
+		if len(area) != 4 {
+			v.add(join(path, "tabletArea"), "expected 4 values x1 y1 x2 y2, got %d", len(area))
+		} else if area[0] < 0 || area[1] < 0 || area[2] <= area[0] || area[3] <= area[1] {
+			v.add(join(path, "tabletArea"), "invalid tablet area %v, expected x1 y1 x2 y2 with x1 < x2 and y1 < y2", area)
+		}
+	}
+	v.target(path, config.Target(), true, true)
+	v.buttons(join(path, "buttons"), config.Buttons)
+	for _, class := range sortedKeys(config.AppButtons) {
+		v.buttons(join(path, "appButtons", class), config.AppButtons[class])
+	}
+	for i, rule := range config.Rules {
+		rulePath := join(path, "rules", strconv.Itoa(i))
+		match := rule.Match
+		if match.Class == "" && match.Title == "" && match.Desktop == nil {
+			v.add(join(rulePath, "match"), "rule matches every window, set class, title or desktop")
+		}
+		if match.Desktop != nil && *match.Desktop < 0 {
+			v.add(join(rulePath, "match", "desktop"), "invalid desktop %d", *match.Desktop)
+		}
+		v.target(rulePath, rule.MappingTarget, false, false)
+	}
+	for i, fallback := range config.Fallbacks {
+		v.target(join(path, "fallbacks", strconv.Itoa(i)), fallback, false, true)
+	}
+}
+
+// target checks a mapping target. Rules may leave the window name empty to
+// map to the matched window.
+func (v *validator) target(path []string, target inputs.MappingTarget, allowEmptyType bool, needsWindowName bool) {
+	if !slices.Contains(validMappingTypes, target.MappingType) && !(allowEmptyType && target.MappingType == "") {
+		v.add(join(path, "mappingType"), "unknown mapping type '%s', expected one of %s", target.MappingType, joinValues(validMappingTypes))
+	}
+	if !slices.Contains(validRotations, target.Rotation) {
+		v.add(join(path, "rotation"), "invalid rotation %d, expected 0, 90, 180 or 270", target.Rotation)
+	}
+	if !slices.Contains(validClipModes, target.Clip) {
+		v.add(join(path, "clip"), "unknown clip mode '%s', expected one of %s", target.Clip, joinValues(validClipModes[1:]))
+	}
+	for _, row := range target.CoordMatrix {
+		for _, value := range row {
+			if math.IsNaN(float64(value)) || math.IsInf(float64(value), 0) {
+				v.add(join(path, "coordMatrix"), "matrix contains %v", value)
+				return
+			}
+		}
+	}
+	switch target.MappingType {
+	case inputs.INPUT_MAPPING_COORD_MATRIX:
+		if determinant(target.CoordMatrix) == 0 {
+			v.add(join(path, "coordMatrix"), "matrix maps the tablet to a line or point")
+		}
+	case inputs.INPUT_MAPPING_WINDOW:
+		if needsWindowName && target.WindowName == "" {
+			v.add(join(path, "windowName"), "window mapping needs a window name")
+		}
+	case inputs.INPUT_MAPPING_REGION:
+		if target.Region == "" {
+			v.add(join(path, "region"), "region mapping needs a region")
+		} else if _, err := outputs.ParseRegion(target.Region); err != nil {
+			v.add(join(path, "region"), "%s", err.Error())
+		}
+	}
+}
+
+func (v *validator) buttons(path []string, buttons map[string]string) {
+	for _, button := range sortedKeys(buttons) {
+		if number, err := strconv.Atoi(button); err != nil || number < 1 {
+			v.add(join(path, button), "invalid button number '%s'", button)
+			continue
+		}
+		if _, err := inputs.ParseButtonAction(buttons[button]); err != nil {
+			v.add(join(path, button), "%s", err.Error())
+		}
+	}
+}
+
+func determinant(m inputs.CoordinationMatrix) float32 {
+	return m[0][0]*(m[1][1]*m[2][2]-m[1][2]*m[2][1]) -
+		m[0][1]*(m[1][0]*m[2][2]-m[1][2]*m[2][0]) +
+		m[0][2]*(m[1][0]*m[2][1]-m[1][1]*m[2][0])
+}
+
+func joinValues[T ~string](values []T) string {
+	parts := make([]string, 0, len(values))
+	for _, value := range values {
+		parts = append(parts, string(value))
+	}
+	return strings.Join(parts, ", ")
+}
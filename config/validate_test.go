@@ -0,0 +1,152 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func validateFile(t *testing.T, name string, content string) (string, []string) {
+	t.Helper()
+	confPath := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(confPath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	layer, err := FileLayer(confPath)
+	if err != nil {
+		t.Fatalf("FileLayer failed: %v", err)
+	}
+	doc, origins, err := MergeLayers([]Layer{layer})
+	if err != nil {
+		t.Fatalf("MergeLayers failed: %v", err)
+	}
+	problems := make([]string, 0)
+	for _, problem := range Validate(doc, origins) {
+		problems = append(problems, problem.Error())
+	}
+	return confPath, problems
+}
+
+func TestValidateReportsLines(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		want    []string
+	}{
+		{
+			"json", "config.json", `{
+  "version": 3,
+  "activeProfile": "default",
+  "profiles": {
+    "default": {
+      "devices": {
+        "HUION H420 Pen stylus": {
+          "mappingType": "desktop",
+          "rotation": 45,
+          "colour": "red"
+        },
+        "HUION Pad pad": {
+          "mappingType": "desktop",
+          "rotation": 0,
+          "buttons": { "1": "button 99" }
+        }
+      }
+    }
+  }
+}
+`,
+			[]string{
+				`:9: profiles.default.devices."HUION H420 Pen stylus".rotation: invalid rotation 45, expected 0, 90, 180 or 270`,
+				`:10: profiles.default.devices."HUION H420 Pen stylus".colour: unknown field`,
+				`:15: profiles.default.devices."HUION Pad pad".buttons.1: Invalid button number '99'`,
+			},
+		},
+		{
+			"version 1", "config.json", `{
+  "HUION H420 Pen stylus": {
+    "mappingType": "window",
+    "widowName": "krita",
+    "rotation": 45
+  }
+}
+`,
+			[]string{
+				`:5: profiles.default.devices."HUION H420 Pen stylus".rotation: invalid rotation 45, expected 0, 90, 180 or 270`,
+			},
+		},
+		{
+			"version 2", "config.json", `{
+  "version": 2,
+  "devices": {
+    "HUION H420 Pen stylus": {
+      "mappingType": "desktop",
+      "rotation": 0,
+      "colour": "red"
+    }
+  }
+}
+`,
+			[]string{
+				`:7: profiles.default.devices."HUION H420 Pen stylus".colour: unknown field`,
+			},
+		},
+		{
+			"yaml", "config.yaml", `version: 3
+activeProfile: docked
+profiles:
+  default:
+    devices:
+      HUION H420 Pen stylus:
+        mappingType: desktop
+        rotation: 45
+`,
+			[]string{
+				`:2: activeProfile: no profile named 'docked'`,
+				`:8: profiles.default.devices."HUION H420 Pen stylus".rotation: invalid rotation 45, expected 0, 90, 180 or 270`,
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			confPath, got := validateFile(t, test.file, test.content)
+			want := make([]string, 0, len(test.want))
+			for _, problem := range test.want {
+				want = append(want, confPath+problem)
+			}
+			if !slices.Equal(got, want) {
+				t.Errorf("Validate =\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestValidateValidConfig(t *testing.T) {
+	_, problems := validateFile(t, "config.json", `{"version": 3, "activeProfile": "default", "profiles": {"default": {"devices": {
+  "HUION H420 Pen stylus": {"mappingType": "output", "outputName": "HDMI-1", "rotation": 90}
+}}}}`)
+	if len(problems) > 0 {
+		t.Errorf("Validate = %q, want no problems", problems)
+	}
+}
+
+func TestFileLayerReportsLinesOfOlderVersions(t *testing.T) {
+	confPath := filepath.Join(t.TempDir(), "config.json")
+	err := os.WriteFile(confPath, []byte(`{
+  "HUION H420 Pen stylus": {
+    "mappingType": "desktop",
+    "rotation": "ninety"
+  }
+}
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = FileLayer(confPath)
+	var validationErr ValidationError
+	if !errors.As(err, &validationErr) || validationErr.Line != 4 {
+		t.Errorf("FileLayer = %v, want an error on line 4", err)
+	}
+}
@@ -0,0 +1,85 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// watchEvents are the inotify events after which a file has new content:
+// written in place, or replaced by renaming another file over it.
+const watchEvents = syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO
+
+// layerEvents are the inotify events after which a system layer is new,
+// changed or gone.
+const layerEvents = watchEvents | syscall.IN_DELETE | syscall.IN_MOVED_FROM
+
+// WatchConfig sends on changes whenever the user config at confPath gets new
+// content or a system layer in SYSTEM_CONFIG_DIR is added, changed or
+// removed. Directories are watched rather than files, so atomic saves which
+// rename a new file over the config are noticed, as well as edits of the
+// file a symlink at confPath points to. Changes are dropped while a previous
+// one hasn't been received, so changes should be buffered. It blocks until
+// reading the events fails.
+func WatchConfig(confPath string, changes chan<- struct{}) error {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if err != nil {
+		return fmt.Errorf("Couldn't watch config file %w", err)
+	}
+	defer syscall.Close(fd)
+
+	paths := []string{confPath}
+	if target, err := filepath.EvalSymlinks(confPath); err == nil && target != confPath {
+		paths = append(paths, target)
+	}
+	// names maps the watch descriptor of a directory to the files watched
+	// in it. A symlink and its target may be in the same directory.
+	names := map[int32]map[string]bool{}
+	for _, path := range paths {
+		wd, err := syscall.InotifyAddWatch(fd, filepath.Dir(path), watchEvents|syscall.IN_MASK_ADD)
+		if err != nil {
+			return fmt.Errorf("Couldn't watch config file %s %w", path, err)
+		}
+		if names[int32(wd)] == nil {
+			names[int32(wd)] = map[string]bool{}
+		}
+		names[int32(wd)][filepath.Base(path)] = true
+	}
+	layersWd := int32(-1)
+	if wd, err := syscall.InotifyAddWatch(fd, SYSTEM_CONFIG_DIR, layerEvents|syscall.IN_MASK_ADD); err == nil {
+		layersWd = int32(wd)
+	} else if !errors.Is(err, syscall.ENOENT) {
+		log.Printf("WARN: couldn't watch the system config in %s. %s", SYSTEM_CONFIG_DIR, err.Error())
+	}
+
+	buf := make([]byte, 4096)
+	for {
+		n, err := syscall.Read(fd, buf)
+		if err != nil {
+			return fmt.Errorf("Couldn't read config file changes %w", err)
+		}
+		changed := false
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameStart := offset + syscall.SizeofInotifyEvent
+			name := strings.TrimRight(string(buf[nameStart:nameStart+int(event.Len)]), "\x00")
+			if names[event.Wd][name] {
+				changed = true
+			}
+			if _, ok := formatExtensions[filepath.Ext(name)]; ok && event.Wd == layersWd {
+				changed = true
+			}
+			offset = nameStart + int(event.Len)
+		}
+		if changed {
+			select {
+			case changes <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
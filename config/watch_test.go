@@ -0,0 +1,43 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatchConfigSymlinkInSameDirectory(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "real.json")
+	link := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(target, []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("real.json", link); err != nil {
+		t.Fatal(err)
+	}
+	changes := make(chan struct{}, 1)
+	go WatchConfig(link, changes)
+	// Give the watcher time to add its watches.
+	time.Sleep(100 * time.Millisecond)
+
+	for _, path := range []string{target, link} {
+		if err := os.WriteFile(filepath.Join(dir, "other.json"), []byte("{}"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(`{"version": 3}`), 0644); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-changes:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("no change after writing %s", path)
+		}
+	}
+	select {
+	case <-changes:
+		t.Errorf("unexpected change")
+	case <-time.After(200 * time.Millisecond):
+	}
+}
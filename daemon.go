@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	tm_config "tablet_mapper/config"
+	tm_inputs "tablet_mapper/inputs"
+	"tablet_mapper/outputs"
+	"tablet_mapper/windows"
+	"time"
+)
+
+const defaultButtonSet = "default"
+
+const layoutPollInterval = 5 * time.Second
+
+// buttonSetFor picks the button set of the first application in AppButtons
+// matching the window's WM_CLASS, falling back to the default Buttons.
+func buttonSetFor(config tm_inputs.InputConfig, window windows.Window) (string, map[string]string) {
+	classes := make([]string, 0, len(config.AppButtons))
+	for class := range config.AppButtons {
+		classes = append(classes, class)
+	}
+	sort.Strings(classes)
+	for _, class := range classes {
+		if window.MatchesClass(class) {
+			return class, config.AppButtons[class]
+		}
+	}
+	return defaultButtonSet, config.Buttons
+}
+
+type daemon struct {
+	inputs      []tm_inputs.Input
+	doc         tm_config.Document
+	profile     string
+	confPath    string
+	profileFlag string
+	// followLayout switches to the profile matching the monitor layout
+	// whenever it changes.
+	followLayout bool
+	fingerprint  string
+	focusedId    string
+	appliedSets  map[string]string
+	appliedAreas map[string]tm_inputs.CoordinationMatrix
+}
+
+func newDaemon(inputs []tm_inputs.Input, doc tm_config.Document, profile string, confPath string, profileFlag string, followLayout bool, appliedAreas map[string]tm_inputs.CoordinationMatrix) *daemon {
+	d := &daemon{
+		inputs:       inputs,
+		doc:          doc,
+		profile:      profile,
+		confPath:     confPath,
+		profileFlag:  profileFlag,
+		followLayout: followLayout,
+		appliedSets:  make(map[string]string),
+		appliedAreas: appliedAreas,
+	}
+	if layout, err := outputs.GetLayout(); err == nil {
+		d.fingerprint = layout.Fingerprint()
+	}
+	return d
+}
+
+// run watches for focus and desktop changes, windows being opened or closed,
+// monitor layout changes and changes of the config file, and re-applies the
+// button sets and areas of every input. It only returns if watching windows
+// fails.
+func (d *daemon) run() error {
+	windowChanges := make(chan windows.RootPropertyChange)
+	layoutChanges := make(chan struct{})
+	configChanges := make(chan struct{}, 1)
+	errs := make(chan error, 1)
+	go func() {
+		props := []string{windows.PROP_ACTIVE_WINDOW, windows.PROP_CLIENT_LIST, windows.PROP_CURRENT_DESKTOP}
+		errs <- windows.WatchRootProperties(props, windowChanges)
+	}()
+	go func() {
+		if err := outputs.WatchLayoutChanges(layoutChanges); err != nil {
+			log.Printf("WARN: %s, polling for layout changes instead", err.Error())
+		}
+		outputs.PollLayoutChanges(layoutPollInterval, layoutChanges)
+	}()
+	go func() {
+		if err := tm_config.WatchConfig(d.confPath, configChanges); err != nil {
+			log.Printf("WARN: %s, the config won't be reloaded on changes", err.Error())
+		}
+	}()
+
+	for {
+		select {
+		case err := <-errs:
+			return fmt.Errorf("Stopped watching window changes %v", err)
+		case change := <-windowChanges:
+			if change.Name == windows.PROP_ACTIVE_WINDOW {
+				d.focusedId = change.Value
+			}
+		case <-layoutChanges:
+			d.checkLayout()
+		case <-configChanges:
+			d.reload()
+		}
+		d.apply()
+	}
+}
+
+// checkLayout switches to the profile matching the monitor layout if it
+// changed.
+func (d *daemon) checkLayout() {
+	layout, err := outputs.GetLayout()
+	if err != nil {
+		log.Printf("WARN: %s", err.Error())
+		return
+	}
+	fingerprint := layout.Fingerprint()
+	if fingerprint == d.fingerprint {
+		return
+	}
+	log.Printf("INFO: monitor layout changed to %s", fingerprint)
+	d.fingerprint = fingerprint
+	if !d.followLayout {
+		return
+	}
+	if name, ok := d.doc.ProfileForLayout(layout); ok && name != d.profile {
+		d.switchProfile(name)
+	}
+}
+
+func (d *daemon) switchProfile(name string) {
+	log.Printf("INFO: switching to profile '%s'", name)
+	d.profile = name
+	d.appliedSets = make(map[string]string)
+	d.appliedAreas, _ = applyDevices(d.inputs, d.doc.Profiles[name].Devices)
+}
+
+// reload re-applies the inputs whose config changed in the config file.
+func (d *daemon) reload() {
+	doc, name, profile, ok := reloadConfig(d.confPath, d.profileFlag, !d.followLayout)
+	if !ok {
+		return
+	}
+	d.doc, d.profile = doc, name
+	for _, changed := range applyChangedDevices(d.inputs, profile.Devices, d.appliedAreas) {
+		delete(d.appliedSets, changed)
+	}
+}
+
+func (d *daemon) apply() {
+	state := readScreenState(d.focusedId)
+	for _, input := range d.inputs {
+		d.applyButtonSet(input, state.focused)
+		applyArea(input, state, d.appliedAreas)
+	}
+}
+
+func (d *daemon) applyButtonSet(input tm_inputs.Input, focused windows.Window) {
+	if len(input.Config.AppButtons) == 0 {
+		return
+	}
+	setName, buttons := buttonSetFor(input.Config, focused)
+	if applied, ok := d.appliedSets[input.Name]; ok && applied == setName {
+		return
+	}
+	log.Printf("INFO: applying button set '%s' to '%s' for window '%s'", setName, input.Name, focused.Class)
+	input.Config.Buttons = buttons
+	if err := input.MapButtons(); err != nil {
+		log.Printf("WARN: couldn't map buttons of '%s'. %s", input.Name, err.Error())
+		return
+	}
+	d.appliedSets[input.Name] = setName
+}
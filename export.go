@@ -0,0 +1,423 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"maps"
+	"math"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	tm_config "tablet_mapper/config"
+	tm_inputs "tablet_mapper/inputs"
+	"tablet_mapper/outputs"
+)
+
+// exportCommand prints the profile as a config for other tools, so it can
+// be applied where tablet-mapper isn't installed.
+func exportCommand(args []string, profile string) error {
+	flags, profileFlag := commandFlags("export", profile)
+	format := flags.String("format", "sh", "format to export to: sh, xorg or otd")
+	unitsPerMM := flags.Float64("units-per-mm", 0, "otd: tablet units per millimeter, to convert the tablet area")
+	args, err := parseInterspersed(flags, args)
+	if err != nil {
+		return err
+	}
+	confPath, err := configPathArg(args)
+	if err != nil {
+		return err
+	}
+	if *format != "sh" && *format != "xorg" && *format != "otd" {
+		return usageErrorf("Unknown export format '%s', expected sh, xorg or otd", *format)
+	}
+	s, err := loadConfigSession(confPath, *profileFlag, true)
+	if err != nil {
+		return err
+	}
+	// Xorg has no equivalent to xinput map-to-output.
+	devices, err := exportDevices(s, *format == "sh")
+	if err != nil {
+		return err
+	}
+	switch *format {
+	case "xorg":
+		fmt.Print(renderXorgConfig(s, devices))
+	case "otd":
+		return printOpenTabletDriverSettings(devices, *unitsPerMM)
+	default:
+		fmt.Print(renderShellScript(s, devices))
+	}
+	return nil
+}
+
+// printOpenTabletDriverSettings prints devices as the settings.json of
+// OpenTabletDriver. What can't be converted is reported on stderr.
+func printOpenTabletDriverSettings(devices []exportedDevice, unitsPerMM float64) error {
+	inputs, err := tm_inputs.GetInputs()
+	if err != nil {
+		log.Printf("WARN: %s, only configured tablet areas are exported", err.Error())
+	}
+	otdDevices := make([]tm_config.OTDDevice, 0, len(devices))
+	for _, device := range devices {
+		for _, note := range device.Notes {
+			log.Printf("WARN: %s: %s", device.Name, note)
+		}
+		otdDevice := tm_config.OTDDevice{
+			Name:       device.Name,
+			Display:    device.Display,
+			Rotation:   device.Rotation,
+			Mode:       device.Mode,
+			TabletArea: device.TabletArea,
+			Buttons:    device.Buttons,
+		}
+		// OpenTabletDriver always needs a tablet area, so the current one is
+		// used if the config has none.
+		for _, input := range inputs {
+			if input.Name != device.Name || len(otdDevice.TabletArea) > 0 {
+				continue
+			}
+			if area, err := input.GetTabletArea(); err != nil {
+				log.Printf("WARN: %s", err.Error())
+			} else {
+				otdDevice.TabletArea = area
+			}
+		}
+		otdDevices = append(otdDevices, otdDevice)
+	}
+	settings, problems := tm_config.ExportOpenTabletDriver(otdDevices, unitsPerMM)
+	for _, problem := range problems {
+		fmt.Fprintln(os.Stderr, problem.Error())
+	}
+	return printJSON(settings)
+}
+
+// exportedDevice is a configured device resolved to what a static config,
+// like a shell script, can set.
+type exportedDevice struct {
+	Name string
+	// USBId is nil when the device isn't connected while exporting.
+	USBId *tm_inputs.USBId
+	// Area is the matrix to set, nil if the device keeps its area or is
+	// mapped with Output instead.
+	Area *tm_inputs.CoordinationMatrix
+	// Display is the part of the screen Area maps to, before Rotation,
+	// nil if the layout is unknown.
+	Display  *outputs.Rect
+	Rotation int
+	// Output is the output to map the device to when it is mapped to a
+	// whole output without rotation, which doesn't depend on the layout.
+	Output     string
+	Mode       string
+	TabletArea []int
+	Buttons    map[string]string
+	// Notes explain which parts of the config can't be exported.
+	Notes []string
+}
+
+// exportDevices resolves the devices of the profile against the current
+// monitor layout. Mappings following windows and per-application buttons
+// need the daemon, so the fallbacks and default buttons are exported
+// instead. Output targets are only kept as outputs if mapOutputs is set.
+func exportDevices(s *session, mapOutputs bool) ([]exportedDevice, error) {
+	state := screenState{}
+	var err error
+	if state.layout, err = outputs.GetLayout(); err != nil {
+		log.Printf("WARN: %s, only fixed and desktop mappings can be exported", err.Error())
+	}
+	usbIds := map[string]tm_inputs.USBId{}
+	if inputs, err := tm_inputs.GetInputs(); err != nil {
+		log.Printf("WARN: %s, devices are only looked up by name", err.Error())
+	} else {
+		for _, input := range inputs {
+			if id, ok, err := input.GetUSBId(); err != nil {
+				log.Printf("WARN: %s", err.Error())
+			} else if ok {
+				usbIds[input.Name] = id
+			}
+		}
+	}
+
+	names := make([]string, 0, len(s.devices))
+	for name := range s.devices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	devices := make([]exportedDevice, 0, len(names))
+	for _, name := range names {
+		config := s.devices[name]
+		device := exportedDevice{Name: name, Mode: config.Mode, TabletArea: config.TabletArea, Buttons: map[string]string{}}
+		for button, action := range config.Buttons {
+			if device.Buttons[button], err = tm_inputs.ParseButtonAction(action); err != nil {
+				return nil, fmt.Errorf("Couldn't export button %s of '%s' %w", button, name, err)
+			}
+		}
+		if id, ok := usbIds[name]; ok {
+			device.USBId = &id
+		}
+		if len(config.Rules) > 0 {
+			device.Notes = append(device.Notes, "window rules need the daemon and are left out")
+		}
+		if len(config.AppButtons) > 0 {
+			device.Notes = append(device.Notes, "per-application buttons need the daemon, the default buttons are set")
+		}
+
+		targets := []tm_inputs.MappingTarget{config.Target()}
+		if config.MappingType == tm_inputs.INPUT_MAPPING_WINDOW {
+			device.Notes = append(device.Notes, fmt.Sprintf("mapping to window '%s' needs the daemon, using the fallbacks", config.WindowName))
+			targets = make([]tm_inputs.MappingTarget, 0)
+			for _, fallback := range config.FallbackTargets() {
+				if fallback.MappingType != tm_inputs.INPUT_MAPPING_WINDOW {
+					targets = append(targets, fallback)
+				}
+			}
+		}
+		if config.MappingType == "" {
+			targets = nil
+		}
+		for _, target := range targets {
+			if mapOutputs && target.MappingType == tm_inputs.INPUT_MAPPING_OUTPUT && target.OutputName != "" && target.Rotation == 0 {
+				device.Output = target.OutputName
+				break
+			}
+			coordMatrix, err := resolveTarget(target, state)
+			if err != nil {
+				log.Printf("WARN: couldn't export the %s mapping of '%s'. %s", target.MappingType, name, err.Error())
+				continue
+			}
+			switch target.MappingType {
+			case tm_inputs.INPUT_MAPPING_REGION, tm_inputs.INPUT_MAPPING_OUTPUT:
+				device.Notes = append(device.Notes, fmt.Sprintf("the %s mapping is fixed to the current monitor layout %s", describeTarget(target), state.layout.Fingerprint()))
+			}
+			device.Area = &coordMatrix
+			if target.MappingType != tm_inputs.INPUT_MAPPING_COORD_MATRIX {
+				device.Rotation = target.Rotation
+			}
+			if state.layout.Width > 0 && state.layout.Height > 0 {
+				rect := areaRect(coordMatrix, device.Rotation, state.layout)
+				device.Display = &rect
+			}
+			break
+		}
+		if len(targets) > 0 && device.Area == nil && device.Output == "" {
+			return nil, fmt.Errorf("Couldn't export the mapping of '%s'", name)
+		}
+		devices = append(devices, device)
+	}
+	return devices, nil
+}
+
+// areaRect returns the part of the screen the matrix m maps to, undoing the
+// rotation it was built with.
+func areaRect(m tm_inputs.CoordinationMatrix, rotation int, layout outputs.Layout) outputs.Rect {
+	unrotated := m.MultiplyCoordMatrices(tm_inputs.GetCoordinateMatrix((360 - rotation) % 360))
+	scale := func(value float32, total int) int {
+		return int(math.Round(float64(value) * float64(total)))
+	}
+	return outputs.Rect{
+		X:      scale(unrotated[0][2], layout.Width),
+		Y:      scale(unrotated[1][2], layout.Height),
+		Width:  scale(unrotated[0][0], layout.Width),
+		Height: scale(unrotated[1][1], layout.Height),
+	}
+}
+
+// sortedButtons returns the numbers of the configured buttons in order.
+func (device exportedDevice) sortedButtons() []string {
+	buttons := make([]string, 0, len(device.Buttons))
+	for button := range device.Buttons {
+		buttons = append(buttons, button)
+	}
+	sort.Slice(buttons, func(i, j int) bool {
+		return len(buttons[i]) < len(buttons[j]) || len(buttons[i]) == len(buttons[j]) && buttons[i] < buttons[j]
+	})
+	return buttons
+}
+
+// findDeviceFunction looks up a device by name or, if no device has that
+// name, by USB id and the last word of the name, e.g. "stylus".
+const findDeviceFunction = `# find_device NAME [VENDOR PRODUCT] prints the xinput id of the device NAME.
+# Without one, it looks for a device with the same USB id, given in decimal,
+# whose name ends with the same word, e.g. "stylus".
+find_device() {
+	if id=$(xinput list --id-only "$1" 2>/dev/null); then
+		echo "$id"
+		return 0
+	fi
+	[ $# -eq 3 ] || return 1
+	for id in $(xinput list --id-only); do
+		case "$(xinput list --name-only "$id")" in
+		*" ${1##* }") ;;
+		*) continue ;;
+		esac
+		if xinput list-props "$id" | grep -q "Device Product ID ([0-9]*):[[:space:]]*$2, $3\$"; then
+			echo "$id"
+			return 0
+		fi
+	done
+	return 1
+}
+`
+
+// deviceCommand formats a command taking the device as its second argument,
+// which the script has in $id.
+func deviceCommand(command string, subcommand string, args ...string) string {
+	return tm_inputs.ShellQuote(command, subcommand) + ` "$id" ` + tm_inputs.ShellQuote(args...)
+}
+
+// renderShellScript renders devices as a POSIX shell script of xinput and
+// xsetwacom calls. It exits with 1 if any device isn't connected.
+func renderShellScript(s *session, devices []exportedDevice) string {
+	script := strings.Builder{}
+	script.WriteString("#!/bin/sh\n")
+	fmt.Fprintf(&script, "# Exported by tablet-mapper from profile '%s' of %s.\n\n", s.profileName, s.confPath)
+	script.WriteString(findDeviceFunction)
+	script.WriteString("\nstatus=0\n")
+	for _, device := range devices {
+		find := []string{device.Name}
+		if device.USBId != nil {
+			find = append(find, fmt.Sprint(device.USBId.Vendor), fmt.Sprint(device.USBId.Product))
+			fmt.Fprintf(&script, "\n# %s, USB id %s\n", device.Name, device.USBId)
+		} else {
+			fmt.Fprintf(&script, "\n# %s\n", device.Name)
+		}
+		for _, note := range device.Notes {
+			fmt.Fprintf(&script, "# Note: %s.\n", note)
+		}
+		fmt.Fprintf(&script, "if id=$(find_device %s); then\n", tm_inputs.ShellQuote(find...))
+		if device.Mode != "" {
+			fmt.Fprintf(&script, "\t%s\n", deviceCommand("xsetwacom", "--set", "Mode", device.Mode))
+		}
+		if len(device.TabletArea) > 0 {
+			args := []string{"Area"}
+			for _, value := range device.TabletArea {
+				args = append(args, strconv.Itoa(value))
+			}
+			fmt.Fprintf(&script, "\t%s\n", deviceCommand("xsetwacom", "--set", args...))
+		}
+		if device.Output != "" {
+			fmt.Fprintf(&script, "\t%s\n", deviceCommand("xinput", "map-to-output", device.Output))
+		}
+		if device.Area != nil {
+			args := append([]string{"--type=float", tm_inputs.COORD_MATRIX_PROPERTY}, device.Area.PropertyValues()...)
+			fmt.Fprintf(&script, "\t%s\n", deviceCommand("xinput", "set-prop", args...))
+		}
+		buttons := device.sortedButtons()
+		for _, button := range buttons {
+			fmt.Fprintf(&script, "\t%s\n", deviceCommand("xsetwacom", "--set", "Button", button, device.Buttons[button]))
+		}
+		if device.Mode == "" && len(device.TabletArea) == 0 && device.Output == "" && device.Area == nil && len(buttons) == 0 {
+			script.WriteString("\t:\n")
+		}
+		script.WriteString("else\n")
+		fmt.Fprintf(&script, "\techo %s >&2\n", tm_inputs.ShellQuote(fmt.Sprintf("%s isn't connected", device.Name)))
+		script.WriteString("\tstatus=1\nfi\n")
+	}
+	script.WriteString("exit $status\n")
+	return script.String()
+}
+
+// wacomDeviceTypes are the words the wacom driver appends to the product
+// name for each of the devices a tablet has.
+var wacomDeviceTypes = []string{"stylus", "eraser", "cursor", "pad", "touch"}
+
+// productName returns the name the kernel reports for the tablet a device
+// belongs to, e.g. "HUION H420 Pen" for "HUION H420 Pen stylus".
+func productName(device string) string {
+	if i := strings.LastIndex(device, " "); i > 0 && slices.Contains(wacomDeviceTypes, device[i+1:]) {
+		return device[:i]
+	}
+	return device
+}
+
+// xorgString quotes a string for xorg.conf, which has no escapes.
+func xorgString(text string) string {
+	return `"` + strings.ReplaceAll(text, `"`, "") + `"`
+}
+
+// xorgSection is the devices one InputClass section applies to. The wacom
+// driver matches the tablet by its product name, so e.g. the stylus and the
+// eraser of a pen share a section.
+type xorgSection struct {
+	product string
+	devices []exportedDevice
+}
+
+func xorgSections(devices []exportedDevice) []xorgSection {
+	sections := make([]xorgSection, 0, len(devices))
+	for _, device := range devices {
+		product := productName(device.Name)
+		i := slices.IndexFunc(sections, func(section xorgSection) bool { return section.product == product })
+		if i < 0 {
+			sections = append(sections, xorgSection{product: product})
+			i = len(sections) - 1
+		}
+		sections[i].devices = append(sections[i].devices, device)
+	}
+	return sections
+}
+
+// sameXorgOptions reports whether a and b are exported to the same options.
+func sameXorgOptions(a exportedDevice, b exportedDevice) bool {
+	return a.Mode == b.Mode && slices.Equal(a.TabletArea, b.TabletArea) &&
+		(a.Area == nil) == (b.Area == nil) && (a.Area == nil || *a.Area == *b.Area) &&
+		maps.Equal(a.Buttons, b.Buttons)
+}
+
+// renderXorgConfig renders devices as InputClass sections for the wacom
+// driver, which apply the mapping as soon as the X server starts.
+func renderXorgConfig(s *session, devices []exportedDevice) string {
+	conf := strings.Builder{}
+	fmt.Fprintf(&conf, "# Exported by tablet-mapper from profile '%s' of %s.\n", s.profileName, s.confPath)
+	conf.WriteString("# Save as /etc/X11/xorg.conf.d/50-tablet-mapper.conf and restart the X server.\n")
+	for _, section := range xorgSections(devices) {
+		// The options are those of the first device of the tablet.
+		device := section.devices[0]
+		conf.WriteString("\nSection \"InputClass\"\n")
+		fmt.Fprintf(&conf, "\tIdentifier %s\n", xorgString("tablet-mapper "+section.product))
+		for _, other := range section.devices {
+			for _, note := range other.Notes {
+				if len(section.devices) > 1 {
+					note = other.Name + ": " + note
+				}
+				fmt.Fprintf(&conf, "\t# Note: %s.\n", note)
+			}
+			if !sameXorgOptions(device, other) {
+				fmt.Fprintf(&conf, "\t# Note: %s shares this section with %s and its different settings are left out, see export --format sh.\n", other.Name, device.Name)
+			}
+			if device.USBId == nil {
+				device.USBId = other.USBId
+			}
+		}
+		if device.USBId != nil {
+			fmt.Fprintf(&conf, "\tMatchUSBID %s\n", xorgString(device.USBId.String()))
+		} else {
+			conf.WriteString("\t# Note: the device wasn't connected while exporting, so it is only matched by name.\n")
+		}
+		fmt.Fprintf(&conf, "\tMatchProduct %s\n", xorgString(section.product))
+		conf.WriteString("\tMatchDevicePath \"/dev/input/event*\"\n")
+		conf.WriteString("\tDriver \"wacom\"\n")
+		if device.Mode != "" {
+			fmt.Fprintf(&conf, "\tOption \"Mode\" %s\n", xorgString(device.Mode))
+		}
+		if len(device.TabletArea) == 4 {
+			for i, option := range []string{"TopX", "TopY", "BottomX", "BottomY"} {
+				fmt.Fprintf(&conf, "\tOption %s \"%d\"\n", xorgString(option), device.TabletArea[i])
+			}
+		}
+		if device.Area != nil {
+			fmt.Fprintf(&conf, "\tOption \"TransformationMatrix\" %s\n", xorgString(strings.Join(device.Area.PropertyValues(), " ")))
+		}
+		for _, button := range device.sortedButtons() {
+			action := device.Buttons[button]
+			if number, ok := tm_inputs.ClickedButton(action); ok {
+				fmt.Fprintf(&conf, "\tOption %s \"%d\"\n", xorgString("Button"+button), number)
+			} else {
+				fmt.Fprintf(&conf, "\t# Note: button %s '%s' can only be set with xsetwacom, see export --format sh.\n", button, action)
+			}
+		}
+		conf.WriteString("EndSection\n")
+	}
+	return conf.String()
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	tm_inputs "tablet_mapper/inputs"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+// exportTestDevices covers a connected device with a mode, a tablet area, a
+// matrix and buttons, one mapped to an output which isn't connected and one
+// without settings.
+func exportTestDevices() []exportedDevice {
+	return []exportedDevice{
+		{
+			Name:       "HUION H420 Pen stylus",
+			USBId:      &tm_inputs.USBId{Vendor: 0x256c, Product: 0x006e},
+			Mode:       tm_inputs.INPUT_MODE_ABSOLUTE,
+			TabletArea: []int{0, 0, 16000, 10000},
+			Area: &tm_inputs.CoordinationMatrix{
+				{0.5, 0, 0.5},
+				{0, 1, 0},
+				{0, 0, 1},
+			},
+			Buttons: map[string]string{"2": "key +ctrl +z -z -ctrl", "3": "button +1 -1", "10": "3"},
+			Notes:   []string{"mapping to window 'krita' needs the daemon, using the fallbacks"},
+		},
+		{
+			Name:   "HUION H420 Pad pad",
+			Output: "HDMI-1",
+		},
+		{
+			Name: "It's a \"tablet\" $HOME",
+		},
+	}
+}
+
+// checkGolden compares got to the file testdata/name, or writes it with
+// -update.
+func checkGolden(t *testing.T, name string, got string) {
+	t.Helper()
+	golden := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(golden, []byte(got), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != string(want) {
+		t.Errorf("output differs from %s, got\n%s", golden, got)
+	}
+}
+
+func TestRenderShellScript(t *testing.T) {
+	s := &session{confPath: "/home/me/.config/tablet-mapper/config.json", profileName: "default"}
+	script := renderShellScript(s, exportTestDevices())
+	checkGolden(t, "export.sh", script)
+
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no sh")
+	}
+	cmd := exec.Command("sh", "-n")
+	cmd.Stdin = strings.NewReader(script)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Errorf("sh -n failed: %v %s", err, out)
+	}
+}
+
+func TestRenderXorgConfig(t *testing.T) {
+	s := &session{confPath: "/home/me/.config/tablet-mapper/config.json", profileName: "default"}
+	devices := exportTestDevices()
+	// The eraser shares the section of the stylus.
+	eraser := devices[0]
+	eraser.Name = "HUION H420 Pen eraser"
+	eraser.Buttons = map[string]string{"2": "button +3 -3"}
+	eraser.Notes = nil
+	devices = append(devices, eraser)
+	checkGolden(t, "export.conf", renderXorgConfig(s, devices))
+}
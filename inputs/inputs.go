@@ -3,7 +3,9 @@ package inputs
 import (
 	"fmt"
 	"log"
+	"math"
 	"os/exec"
+	"slices"
 	"strconv"
 	"strings"
 )
@@ -11,25 +13,281 @@ import (
 const (
 	INPUT_MAPPING_COORD_MATRIX = "transformation_matrix"
 	INPUT_MAPPING_WINDOW       = "window"
+	INPUT_MAPPING_DESKTOP      = "desktop"
+	INPUT_MAPPING_OUTPUT       = "output"
+	INPUT_MAPPING_REGION       = "region"
+)
+
+// COORD_MATRIX_PROPERTY is the xinput property holding the area an input is
+// mapped to.
+const COORD_MATRIX_PROPERTY = "Coordinate Transformation Matrix"
+
+const (
+	INPUT_CLIP_NONE    = ""
+	INPUT_CLIP_SCREEN  = "screen"
+	INPUT_CLIP_MONITOR = "monitor"
+)
+
+// Tracking modes of xsetwacom: absolute maps the tablet surface to the
+// screen, relative moves the pointer like a mouse.
+const (
+	INPUT_MODE_ABSOLUTE = "absolute"
+	INPUT_MODE_RELATIVE = "relative"
 )
 
 type InputMappingType string
 
+// InputClipMode decides how window targets reaching past the visible
+// screen are cut down before mapping.
+type InputClipMode string
+
 type InputConfig struct {
 	Buttons     map[string]string  `json:"buttons"`
 	CoordMatrix CoordinationMatrix `json:"coordMatrix"`
-	WindowName  string             `json:"widowName"`
+	WindowName  string             `json:"windowName"`
 	Rotation    int                `json:"rotation"`
 	MappingType InputMappingType   `json:"mappingType"`
+	OutputName  string             `json:"outputName,omitempty"`
+	// Mode is the tracking mode, INPUT_MODE_ABSOLUTE or
+	// INPUT_MODE_RELATIVE. The device keeps its mode if it is empty.
+	Mode string `json:"mode,omitempty"`
+	// TabletArea is the part of the tablet surface in use, as the corners
+	// x1 y1 x2 y2 in tablet units like the Area of xsetwacom. The device
+	// keeps its area if it is empty.
+	TabletArea []int `json:"tabletArea,omitempty"`
+	// Region is the part of the screen region mappings map to, resolved
+	// against the layout every time it changes. See outputs.ParseRegion.
+	Region string        `json:"region,omitempty"`
+	Clip   InputClipMode `json:"clip,omitempty"`
+	// AppButtons holds button sets keyed by the WM_CLASS of the application
+	// they apply to. Buttons is used when no application matches.
+	AppButtons map[string]map[string]string `json:"appButtons,omitempty"`
+	// Rules switch the mapping target with the focused window. The first
+	// matching rule wins, the target above is used when none matches.
+	Rules []MappingRule `json:"rules,omitempty"`
+	// Fallbacks are tried in order when the target can't be resolved, e.g.
+	// because the window is closed, minimized or on another desktop.
+	Fallbacks []MappingTarget `json:"fallbacks,omitempty"`
+}
+
+// MappingTarget is where the area of an input is mapped to.
+type MappingTarget struct {
+	MappingType InputMappingType   `json:"mappingType"`
+	WindowName  string             `json:"windowName,omitempty"`
+	OutputName  string             `json:"outputName,omitempty"`
+	Region      string             `json:"region,omitempty"`
+	Clip        InputClipMode      `json:"clip,omitempty"`
+	CoordMatrix CoordinationMatrix `json:"coordMatrix"`
+	Rotation    int                `json:"rotation"`
+}
+
+// DefaultFallbacks is used for inputs without configured fallbacks: the
+// primary monitor first, then the whole desktop.
+var DefaultFallbacks = []MappingTarget{
+	{MappingType: INPUT_MAPPING_OUTPUT},
+	{MappingType: INPUT_MAPPING_DESKTOP},
+}
+
+// WindowMatch selects windows by WM_CLASS and/or a part of their title,
+// optionally only while the given desktop is shown. Empty fields are
+// ignored, a match without any field selects nothing.
+type WindowMatch struct {
+	Class   string `json:"class,omitempty"`
+	Title   string `json:"title,omitempty"`
+	Desktop *int   `json:"desktop,omitempty"`
+}
+
+type MappingRule struct {
+	Match WindowMatch `json:"match"`
+	MappingTarget
+}
+
+func (config InputConfig) Target() MappingTarget {
+	return MappingTarget{
+		MappingType: config.MappingType,
+		WindowName:  config.WindowName,
+		OutputName:  config.OutputName,
+		Region:      config.Region,
+		Clip:        config.Clip,
+		CoordMatrix: config.CoordMatrix,
+		Rotation:    config.Rotation,
+	}
+}
+
+func (config InputConfig) FallbackTargets() []MappingTarget {
+	if len(config.Fallbacks) == 0 {
+		return DefaultFallbacks
+	}
+	return config.Fallbacks
+}
+
+// SetMode sets the tracking mode of the input if its config has one.
+func (input Input) SetMode() error {
+	if input.Config.Mode == "" {
+		return nil
+	}
+	if out, err := run("xsetwacom", "--set", strconv.Itoa(input.Id), "Mode", input.Config.Mode); err != nil {
+		return fmt.Errorf("Couldn't set mode of %s %w %s", input.Name, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// SetTabletArea sets the part of the tablet surface in use if the config of
+// the input has one.
+func (input Input) SetTabletArea() error {
+	if len(input.Config.TabletArea) == 0 {
+		return nil
+	}
+	args := []string{"--set", strconv.Itoa(input.Id), "Area"}
+	for _, value := range input.Config.TabletArea {
+		args = append(args, strconv.Itoa(value))
+	}
+	if out, err := run("xsetwacom", args...); err != nil {
+		return fmt.Errorf("Couldn't set tablet area of %s %w %s", input.Name, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// getParameter reads an xsetwacom parameter of the input, e.g. "Mode" or
+// "Button", "3".
+func (input Input) getParameter(args ...string) (string, error) {
+	args = append([]string{"--get", strconv.Itoa(input.Id)}, args...)
+	out, err := exec.Command("xsetwacom", args...).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("Couldn't read %s of %s %w %s", strings.Join(args[2:], " "), input.Name, err, strings.TrimSpace(string(out)))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// GetMode reads the tracking mode of the input, INPUT_MODE_ABSOLUTE or
+// INPUT_MODE_RELATIVE.
+func (input Input) GetMode() (string, error) {
+	mode, err := input.getParameter("Mode")
+	if err != nil {
+		return "", err
+	}
+	mode = strings.ToLower(mode)
+	if mode != INPUT_MODE_ABSOLUTE && mode != INPUT_MODE_RELATIVE {
+		return "", fmt.Errorf("Unknown mode '%s' of %s", mode, input.Name)
+	}
+	return mode, nil
+}
+
+// GetTabletArea reads the part of the tablet surface in use.
+func (input Input) GetTabletArea() ([]int, error) {
+	value, err := input.getParameter("Area")
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Fields(value)
+	if len(fields) != 4 {
+		return nil, fmt.Errorf("Invalid tablet area of %s: '%s'", input.Name, value)
+	}
+	area := make([]int, 0, 4)
+	for _, field := range fields {
+		number, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid tablet area of %s %w", input.Name, err)
+		}
+		area = append(area, number)
+	}
+	return area, nil
+}
+
+// GetFullTabletArea reads the whole tablet surface, which is the default
+// tablet area, from the ranges of the X and Y axes. The driver scales the
+// tablet area to these ranges, so they stay the same when the area changes.
+func (input Input) GetFullTabletArea() ([]int, error) {
+	out, err := exec.Command("xinput", "list", "--long", strconv.Itoa(input.Id)).Output()
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't read the axes of %s %w", input.Name, err)
+	}
+	area, ok := parseAxisRanges(string(out))
+	if !ok {
+		return nil, fmt.Errorf("%s has no absolute X and Y axes", input.Name)
+	}
+	return area, nil
+}
+
+// parseAxisRanges reads the ranges of the Abs X and Abs Y valuators from the
+// output of xinput list --long as an area x1 y1 x2 y2.
+func parseAxisRanges(out string) ([]int, bool) {
+	area := make([]int, 4)
+	found := 0
+	label := ""
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSpace(line)
+		if value, ok := strings.CutPrefix(line, "Label: "); ok {
+			label = value
+			continue
+		}
+		value, ok := strings.CutPrefix(line, "Range: ")
+		if !ok || (label != "Abs X" && label != "Abs Y") {
+			continue
+		}
+		var low, high float64
+		if n, _ := fmt.Sscanf(value, "%f - %f", &low, &high); n != 2 {
+			return nil, false
+		}
+		axis := 0
+		if label == "Abs Y" {
+			axis = 1
+		}
+		area[axis], area[axis+2] = int(math.Round(low)), int(math.Round(high))
+		found |= 1 << axis
+		label = ""
+	}
+	return area, found == 3
+}
+
+// GetButtons reads the actions of the buttons of the input. Buttons doing
+// what their number says, like "button +3" for button 3, are left out.
+func (input Input) GetButtons() (map[string]string, error) {
+	buttons := map[string]string{}
+	for button := 1; button <= MAX_BUTTON; button++ {
+		number := strconv.Itoa(button)
+		action, err := input.getParameter("Button", number)
+		if err != nil {
+			if button == 1 {
+				return nil, err
+			}
+			break
+		}
+		// xsetwacom prints a message instead of an action for the first
+		// button number the device doesn't have.
+		if strings.Contains(strings.ToLower(action), "does not exist") {
+			break
+		}
+		if err := ValidateButtonAction(action); err != nil {
+			log.Printf("WARN: skipping button %s of %s with the unexpected action '%s'. %s", number, input.Name, action, err.Error())
+			continue
+		}
+		if action != number && action != "button +"+number {
+			buttons[number] = action
+		}
+	}
+	return buttons, nil
 }
 
 func (input Input) MapButtons() error {
 
-	for button, key := range input.Config.Buttons {
-		setButtonCmd := exec.Command("xsetwacom", "--set", strconv.Itoa(input.Id),
-			"Button", button, key)
-		defer setButtonCmd.Wait()
-		if out, err := setButtonCmd.CombinedOutput(); err != nil {
+	buttons := make([]string, 0, len(input.Config.Buttons))
+	for button := range input.Config.Buttons {
+		buttons = append(buttons, button)
+	}
+	// Sorted by number, so dry runs print the same commands every time.
+	slices.SortFunc(buttons, func(a, b string) int {
+		numberA, _ := strconv.Atoi(a)
+		numberB, _ := strconv.Atoi(b)
+		return numberA - numberB
+	})
+	for _, button := range buttons {
+		action, err := ParseButtonAction(input.Config.Buttons[button])
+		if err != nil {
+			return fmt.Errorf("Couldn't map button %s of %s %w", button, input.Name, err)
+		}
+		if out, err := run("xsetwacom", "--set", strconv.Itoa(input.Id),
+			"Button", button, action); err != nil {
 			log.Printf("ERROR: %s", out)
 			return err
 		}
@@ -37,6 +295,18 @@ func (input Input) MapButtons() error {
 	return nil
 }
 
+// PropertyValues formats the matrix as the values of the xinput property,
+// row by row.
+func (m CoordinationMatrix) PropertyValues() []string {
+	values := make([]string, 0, 9)
+	for _, row := range m {
+		for _, val := range row {
+			values = append(values, fmt.Sprintf("%f", val))
+		}
+	}
+	return values
+}
+
 func (input Input) MapToArea(m CoordinationMatrix) error {
 
 	//xinput set-prop "<input-name>" --type=float "Coordinate Transformation Matrix" %f 0 %f 0 %f %f 0 0 1
@@ -45,18 +315,11 @@ func (input Input) MapToArea(m CoordinationMatrix) error {
 	args = append(args, "set-prop")
 	args = append(args, input.Name)
 	args = append(args, "--type=float")
-	args = append(args, "Coordinate Transformation Matrix")
-
-	for _, row := range m {
-		for _, val := range row {
-			args = append(args, fmt.Sprintf("%f", val))
-		}
-	}
+	args = append(args, COORD_MATRIX_PROPERTY)
+	args = append(args, m.PropertyValues()...)
 	log.Printf("INFO: area %+v", args)
 
-	setCoordMatrixCmd := exec.Command("xinput", args...)
-	defer setCoordMatrixCmd.Wait()
-	if _, err := setCoordMatrixCmd.Output(); err != nil {
+	if _, err := run("xinput", args...); err != nil {
 		return fmt.Errorf("Couldn't map inputs %w", err)
 	}
 
@@ -155,8 +418,184 @@ func GetInputs() ([]Input, error) {
 }
 
 type Input struct {
-	Id       int
-	Name     string
-	Selected bool
-	Config   InputConfig
+	Id       int         `json:"id"`
+	Name     string      `json:"name"`
+	Selected bool        `json:"-"`
+	Config   InputConfig `json:"-"`
+}
+
+// MAX_BUTTON is the highest button number xsetwacom accepts.
+const MAX_BUTTON = 32
+
+var buttonActionKeywords = []string{"key", "button", "modetoggle", "displaytoggle", "pan"}
+
+// ValidateButtonAction checks that action is an xsetwacom button action,
+// e.g. "3", "key +ctrl +z -z -ctrl" or "button +1 -1".
+func ValidateButtonAction(action string) error {
+	tokens := strings.Fields(action)
+	if len(tokens) == 0 {
+		return fmt.Errorf("Empty button action")
+	}
+	if len(tokens) == 1 {
+		if _, err := strconv.Atoi(tokens[0]); err == nil {
+			return nil
+		}
+	}
+	keyword := ""
+	arguments := 0
+	checkArguments := func() error {
+		if (keyword == "key" || keyword == "button") && arguments == 0 {
+			return fmt.Errorf("'%s' needs at least one argument", keyword)
+		}
+		return nil
+	}
+	for _, token := range tokens {
+		if slices.Contains(buttonActionKeywords, strings.ToLower(token)) {
+			if err := checkArguments(); err != nil {
+				return err
+			}
+			keyword, arguments = strings.ToLower(token), 0
+			continue
+		}
+		switch keyword {
+		case "":
+			return fmt.Errorf("Unknown action '%s', expected one of %s", token, strings.Join(buttonActionKeywords, ", "))
+		case "key":
+			if strings.TrimLeft(token, "+-") == "" {
+				return fmt.Errorf("Missing key name in '%s'", token)
+			}
+		case "button":
+			button, err := strconv.Atoi(strings.TrimLeft(token, "+-"))
+			if err != nil || button < 1 || button > MAX_BUTTON {
+				return fmt.Errorf("Invalid button number '%s'", token)
+			}
+		default:
+			return fmt.Errorf("'%s' takes no arguments, got '%s'", keyword, token)
+		}
+		arguments++
+	}
+	return checkArguments()
+}
+
+// ClickedButton returns the button an action like "3", "button 3",
+// "button +3" or "button +3 -3" clicks. ok is false for other actions, like
+// keys.
+func ClickedButton(action string) (int, bool) {
+	fields := strings.Fields(action)
+	if len(fields) > 1 && strings.EqualFold(fields[0], "button") {
+		fields = fields[1:]
+	}
+	if len(fields) == 0 || len(fields) > 2 {
+		return 0, false
+	}
+	number, err := strconv.Atoi(strings.TrimPrefix(fields[0], "+"))
+	if err != nil || number < 1 || len(fields) == 2 && fields[1] != "-"+strconv.Itoa(number) {
+		return 0, false
+	}
+	return number, true
+}
+
+// KeyAliases are other names for some keys, by their lower case name. Prior
+// and Next are the X names of Page_Up and Page_Down, the others are shorter
+// names button actions take.
+var KeyAliases = map[string]string{
+	"control": "ctrl", "win": "super", "cmd": "super",
+	"enter": "Return", "esc": "Escape", "del": "Delete", "ins": "Insert",
+	"pgup": "Page_Up", "pageup": "Page_Up", "prior": "Page_Up",
+	"pgdn": "Page_Down", "pagedown": "Page_Down", "next": "Page_Down",
+	"-": "minus", "=": "equal", ",": "comma", ".": "period", "/": "slash", "\\": "backslash",
+	";": "semicolon", "'": "apostrophe", "`": "grave", "[": "bracketleft", "]": "bracketright",
+}
+
+// modifierNames are the keys held while the other keys of a combination are
+// typed.
+var modifierNames = []string{"ctrl", "shift", "alt", "super", "hyper", "meta"}
+
+// ModifierName returns the modifier a key stands for regardless of the side
+// of the keyboard, e.g. "ctrl" for "Control_R". ok is false for keys which
+// aren't modifiers.
+func ModifierName(key string) (name string, ok bool) {
+	name = strings.ToLower(strings.TrimSuffix(strings.TrimSuffix(key, "_L"), "_R"))
+	if alias, ok := KeyAliases[name]; ok {
+		name = alias
+	}
+	return name, slices.Contains(modifierNames, name)
+}
+
+// getProperty returns the value of the xinput property named name, as the
+// comma separated fields xinput list-props prints. ok is false if the input
+// doesn't have the property.
+func (input Input) getProperty(name string) (fields []string, ok bool, err error) {
+	cmd := exec.Command("xinput", "list-props", strconv.Itoa(input.Id))
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, false, fmt.Errorf("Couldn't read properties of %s %w", input.Name, err)
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		property, values, found := strings.Cut(strings.TrimSpace(line), ":")
+		// Properties are listed with their number, e.g. "Device Product ID (284)".
+		if !found || !strings.HasPrefix(property, name+" (") {
+			continue
+		}
+		fields = strings.Split(values, ",")
+		for i := range fields {
+			fields[i] = strings.TrimSpace(fields[i])
+		}
+		return fields, true, nil
+	}
+	return nil, false, nil
+}
+
+// GetArea reads the coordinate transformation matrix currently set for the
+// input.
+func (input Input) GetArea() (CoordinationMatrix, error) {
+	fields, ok, err := input.getProperty(COORD_MATRIX_PROPERTY)
+	if err != nil {
+		return CoordinationMatrix{}, err
+	}
+	if !ok || len(fields) != 9 {
+		return CoordinationMatrix{}, fmt.Errorf("%s has no coordinate transformation matrix", input.Name)
+	}
+	var m CoordinationMatrix
+	for i, field := range fields {
+		value, err := strconv.ParseFloat(field, 32)
+		if err != nil {
+			return CoordinationMatrix{}, fmt.Errorf("Invalid matrix of %s %w", input.Name, err)
+		}
+		m[i/3][i%3] = float32(value)
+	}
+	return m, nil
+}
+
+// USBId is the vendor and product id of a USB device.
+type USBId struct {
+	Vendor  uint16
+	Product uint16
+}
+
+// String formats the id like lsusb and the MatchUSBID option of xorg.conf,
+// e.g. "256c:006e".
+func (id USBId) String() string {
+	return fmt.Sprintf("%04x:%04x", id.Vendor, id.Product)
+}
+
+// GetUSBId reads the USB id of the tablet the input belongs to. ok is false
+// for inputs the kernel doesn't report one for.
+func (input Input) GetUSBId() (id USBId, ok bool, err error) {
+	fields, ok, err := input.getProperty("Device Product ID")
+	if err != nil || !ok {
+		return USBId{}, false, err
+	}
+	if len(fields) != 2 {
+		return USBId{}, false, fmt.Errorf("Invalid product id of %s: %v", input.Name, fields)
+	}
+	vendor, err := strconv.ParseUint(fields[0], 10, 16)
+	if err != nil {
+		return USBId{}, false, fmt.Errorf("Invalid vendor id of %s %w", input.Name, err)
+	}
+	product, err := strconv.ParseUint(fields[1], 10, 16)
+	if err != nil {
+		return USBId{}, false, fmt.Errorf("Invalid product id of %s %w", input.Name, err)
+	}
+	return USBId{Vendor: uint16(vendor), Product: uint16(product)}, true, nil
 }
@@ -0,0 +1,141 @@
+package inputs
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestClickedButton(t *testing.T) {
+	tests := []struct {
+		action string
+		want   int
+		wantOk bool
+	}{
+		{"3", 3, true},
+		{"button 3", 3, true},
+		{"button +3", 3, true},
+		{"button +3 -3", 3, true},
+		{"Button +10 -10", 10, true},
+		{"button +3 -2", 0, false},
+		{"button +1 +2", 0, false},
+		{"0", 0, false},
+		{"key +ctrl +z -z -ctrl", 0, false},
+		{"", 0, false},
+	}
+	for _, test := range tests {
+		got, ok := ClickedButton(test.action)
+		if got != test.want || ok != test.wantOk {
+			t.Errorf("ClickedButton(%q) = %d, %v, want %d, %v", test.action, got, ok, test.want, test.wantOk)
+		}
+	}
+}
+
+func TestParseAxisRanges(t *testing.T) {
+	stylus := `HUION H420 Pen stylus                   	id=12	[slave  pointer  (2)]
+	Reporting 4 classes:
+		Class originated from: 12. Type: XIButtonClass
+		Buttons supported: 8
+		Button labels: None None None None None None None None
+		Button state:
+		Class originated from: 12. Type: XIValuatorClass
+		Detail for Valuator 0:
+		  Label: Abs X
+		  Range: 0.000000 - 32767.000000
+		  Resolution: 100000 units/m
+		  Mode: absolute
+		  Current value: 16000.000000
+		Class originated from: 12. Type: XIValuatorClass
+		Detail for Valuator 1:
+		  Label: Abs Y
+		  Range: 100.000000 - 20000.000000
+		  Resolution: 100000 units/m
+		  Mode: absolute
+		  Current value: 10000.000000
+		Class originated from: 12. Type: XIValuatorClass
+		Detail for Valuator 2:
+		  Label: Abs Pressure
+		  Range: 0.000000 - 8191.000000
+		  Resolution: 1 units/m
+		  Mode: absolute
+		  Current value: 0.000000
+`
+	mouse := `Logitech USB Mouse                      	id=9	[slave  pointer  (2)]
+	Reporting 4 classes:
+		Class originated from: 9. Type: XIValuatorClass
+		Detail for Valuator 0:
+		  Label: Rel X
+		  Range: -1.000000 - -1.000000
+		  Resolution: 0 units/m
+		  Mode: relative
+`
+	tests := []struct {
+		name   string
+		out    string
+		want   []int
+		wantOk bool
+	}{
+		{"stylus", stylus, []int{0, 100, 32767, 20000}, true},
+		{"mouse", mouse, nil, false},
+		{"empty", "", nil, false},
+	}
+	for _, test := range tests {
+		got, ok := parseAxisRanges(test.out)
+		if ok != test.wantOk || ok && !slices.Equal(got, test.want) {
+			t.Errorf("parseAxisRanges(%s) = %v, %v, want %v, %v", test.name, got, ok, test.want, test.wantOk)
+		}
+	}
+}
+
+func TestModifierName(t *testing.T) {
+	tests := []struct {
+		key    string
+		want   string
+		wantOk bool
+	}{
+		{"ctrl", "ctrl", true},
+		{"Control_R", "ctrl", true},
+		{"control", "ctrl", true},
+		{"Shift_L", "shift", true},
+		{"SUPER", "super", true},
+		{"Meta_L", "meta", true},
+		{"z", "z", false},
+		{"Return", "return", false},
+	}
+	for _, test := range tests {
+		got, ok := ModifierName(test.key)
+		if got != test.want || ok != test.wantOk {
+			t.Errorf("ModifierName(%q) = %q, %v, want %q, %v", test.key, got, ok, test.want, test.wantOk)
+		}
+	}
+}
+
+func TestGetButtons(t *testing.T) {
+	// A fake xsetwacom printing the actions of a pad with five buttons.
+	dir := t.TempDir()
+	script := `#!/bin/sh
+case "$4" in
+1) echo "key XF86Copy" ;;
+2) echo "button +2" ;;
+3) echo "key +KP_Home -KP_Home" ;;
+4) echo "jump 3" ;;
+5) echo "key +ctrl +z -z -ctrl" ;;
+*) echo "Button number does not exist on device." ;;
+esac
+`
+	if err := os.WriteFile(filepath.Join(dir, "xsetwacom"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	got, err := Input{Id: 13, Name: "HUION H420 Pad pad"}.GetButtons()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"1": "key XF86Copy", "3": "key +KP_Home -KP_Home", "5": "key +ctrl +z -z -ctrl"}
+	if !maps.Equal(got, want) {
+		t.Errorf("GetButtons = %v, want %v", got, want)
+	}
+}
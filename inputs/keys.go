@@ -0,0 +1,185 @@
+package inputs
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// keysyms are the key names button actions may use, as X keysyms.
+var keysyms = []string{
+	"ctrl", "shift", "alt", "super", "hyper", "meta",
+	"Control_L", "Control_R", "Shift_L", "Shift_R", "Alt_L", "Alt_R", "Super_L", "Super_R", "Meta_L", "Meta_R",
+	"Return", "Escape", "Tab", "ISO_Left_Tab", "space", "BackSpace", "Delete", "Insert",
+	"Home", "End", "Page_Up", "Page_Down", "Left", "Right", "Up", "Down",
+	"Print", "Pause", "Scroll_Lock", "Caps_Lock", "Num_Lock", "Menu",
+	"minus", "equal", "plus", "comma", "period", "slash", "backslash", "semicolon", "apostrophe", "grave",
+	"bracketleft", "bracketright", "braceleft", "braceright", "parenleft", "parenright",
+	"less", "greater", "question", "exclam", "at", "numbersign", "dollar", "percent", "asciicircum",
+	"ampersand", "asterisk", "underscore", "colon", "quotedbl", "bar", "asciitilde",
+	"KP_Add", "KP_Subtract", "KP_Multiply", "KP_Divide", "KP_Enter", "KP_Decimal",
+	"XF86AudioRaiseVolume", "XF86AudioLowerVolume", "XF86AudioMute", "XF86AudioPlay",
+	"XF86AudioNext", "XF86AudioPrev", "XF86MonBrightnessUp", "XF86MonBrightnessDown",
+}
+
+func isModifier(keysym string) bool {
+	_, ok := ModifierName(keysym)
+	return ok
+}
+
+// scrollButtons are the buttons X uses for scrolling, by direction.
+var scrollButtons = []string{"up", "down", "left", "right"}
+
+const FIRST_SCROLL_BUTTON = 4
+
+// keysymTable maps the lower case names of keysyms and aliases to keysyms.
+var keysymTable = buildKeysymTable()
+
+func buildKeysymTable() map[string]string {
+	table := map[string]string{}
+	for _, keysym := range keysyms {
+		table[strings.ToLower(keysym)] = keysym
+	}
+	for c := 'a'; c <= 'z'; c++ {
+		table[string(c)] = string(c)
+	}
+	for c := '0'; c <= '9'; c++ {
+		table[string(c)] = string(c)
+		table["kp_"+string(c)] = "KP_" + string(c)
+	}
+	for n := 1; n <= 35; n++ {
+		table["f"+strconv.Itoa(n)] = "F" + strconv.Itoa(n)
+	}
+	for alias, keysym := range KeyAliases {
+		table[alias] = keysym
+	}
+	return table
+}
+
+// LookupKeysym returns the keysym a key name stands for, ignoring case,
+// e.g. "Return" for "enter". ok is false for unknown names.
+func LookupKeysym(name string) (keysym string, ok bool) {
+	keysym, ok = keysymTable[strings.ToLower(name)]
+	return keysym, ok
+}
+
+// isRawAction reports whether action is written in xsetwacom syntax, which
+// starts with a number or one of buttonActionKeywords.
+func isRawAction(action string) bool {
+	fields := strings.Fields(action)
+	if len(fields) == 0 {
+		return true
+	}
+	if _, err := strconv.Atoi(fields[0]); err == nil {
+		return true
+	}
+	return slices.Contains(buttonActionKeywords, strings.ToLower(fields[0]))
+}
+
+// ParseButtonAction converts a button action to xsetwacom syntax. Besides
+// xsetwacom actions, which are returned as they are, it takes
+//
+//	ctrl+z            key combinations, several are typed one after another
+//	ctrl+shift+s f5   when separated by spaces
+//	scroll up         scrolling up, down, left or right
+//
+// Key names of these are checked against a table of keysyms. The keys of
+// xsetwacom actions aren't, as xsetwacom takes any X keysym.
+func ParseButtonAction(action string) (string, error) {
+	if isRawAction(action) {
+		if err := ValidateButtonAction(action); err != nil {
+			return "", err
+		}
+		return action, nil
+	}
+	fields := strings.Fields(action)
+	if strings.EqualFold(fields[0], "scroll") {
+		if len(fields) == 2 {
+			if i := slices.Index(scrollButtons, strings.ToLower(fields[1])); i >= 0 {
+				return fmt.Sprintf("button +%d -%d", FIRST_SCROLL_BUTTON+i, FIRST_SCROLL_BUTTON+i), nil
+			}
+		}
+		return "", fmt.Errorf("Invalid scroll action '%s', expected scroll %s", action, strings.Join(scrollButtons, "|"))
+	}
+	tokens := []string{"key"}
+	for _, combination := range fields {
+		keys := strings.Split(combination, "+")
+		presses := make([]string, 0, len(keys))
+		releases := make([]string, 0, len(keys))
+		for i, name := range keys {
+			keysym, ok := LookupKeysym(name)
+			if !ok {
+				if name == "" {
+					return "", fmt.Errorf("Missing key name in '%s', use 'plus' for the + key", combination)
+				}
+				return "", fmt.Errorf("Unknown key '%s' in '%s'", name, combination)
+			}
+			if i < len(keys)-1 && !isModifier(keysym) {
+				return "", fmt.Errorf("'%s' in '%s' isn't a modifier like ctrl, shift, alt or super", name, combination)
+			}
+			presses = append(presses, "+"+keysym)
+			releases = append([]string{"-" + keysym}, releases...)
+		}
+		tokens = append(append(tokens, presses...), releases...)
+	}
+	return strings.Join(tokens, " "), nil
+}
+
+// FormatButtonAction is the reverse of ParseButtonAction. It describes an
+// xsetwacom action in the friendly syntax where there is one, e.g.
+// "ctrl+z" for "key +ctrl +z -z -ctrl", and returns other actions as they
+// are.
+func FormatButtonAction(action string) string {
+	fields := strings.Fields(action)
+	if len(fields) == 0 {
+		return action
+	}
+	if number, ok := ClickedButton(action); ok {
+		if scroll := number - FIRST_SCROLL_BUTTON; scroll >= 0 && scroll < len(scrollButtons) {
+			return "scroll " + scrollButtons[scroll]
+		}
+		return "button " + strconv.Itoa(number)
+	}
+	if !strings.EqualFold(fields[0], "key") || len(fields) == 1 {
+		return action
+	}
+	combinations := make([]string, 0)
+	held := make([]string, 0)
+	pending := false
+	for _, token := range fields[1:] {
+		// Keys which don't read back as the same keysym, like Z for z, are
+		// left as they are.
+		keysym, ok := LookupKeysym(strings.TrimLeft(token, "+-"))
+		if !ok || keysym != strings.TrimLeft(token, "+-") {
+			return action
+		}
+		switch {
+		case strings.HasPrefix(token, "-"):
+			i := slices.Index(held, keysym)
+			if i < 0 {
+				return action
+			}
+			if pending {
+				combinations = append(combinations, strings.Join(held, "+"))
+				pending = false
+			}
+			held = slices.Delete(held, i, i+1)
+		case strings.HasPrefix(token, "+"):
+			if pending && !isModifier(held[len(held)-1]) {
+				return action
+			}
+			held = append(held, keysym)
+			pending = true
+		case isModifier(keysym):
+			// A modifier typed on its own doesn't read as a combination.
+			return action
+		default:
+			combinations = append(combinations, strings.Join(append(slices.Clone(held), keysym), "+"))
+		}
+	}
+	if len(held) > 0 {
+		return action
+	}
+	return strings.Join(combinations, " ")
+}
@@ -0,0 +1,99 @@
+package inputs
+
+import (
+	"testing"
+)
+
+func TestParseButtonAction(t *testing.T) {
+	tests := []struct {
+		action string
+		want   string
+		format string
+	}{
+		{"ctrl+z", "key +ctrl +z -z -ctrl", "ctrl+z"},
+		{"ctrl+shift+s", "key +ctrl +shift +s -s -shift -ctrl", "ctrl+shift+s"},
+		{"ctrl+plus", "key +ctrl +plus -plus -ctrl", "ctrl+plus"},
+		{"Control+Z", "key +ctrl +z -z -ctrl", "ctrl+z"},
+		{"ctrl+c ctrl+v", "key +ctrl +c -c -ctrl +ctrl +v -v -ctrl", "ctrl+c ctrl+v"},
+		{"f5", "key +F5 -F5", "F5"},
+		{"enter", "key +Return -Return", "Return"},
+		{"pgup", "key +Page_Up -Page_Up", "Page_Up"},
+		{"scroll up", "button +4 -4", "scroll up"},
+		{"scroll right", "button +7 -7", "scroll right"},
+		{"button 3", "button 3", "button 3"},
+		{"button +3 -3", "button +3 -3", "button 3"},
+		{"3", "3", "button 3"},
+		{"key +ctrl +z -z -ctrl", "key +ctrl +z -z -ctrl", "ctrl+z"},
+		{"key e", "key e", "e"},
+		{"key +Control_L +z -z -Control_L", "key +Control_L +z -z -Control_L", "Control_L+z"},
+		{"key +ctrl", "key +ctrl", "key +ctrl"},
+		{"modetoggle", "modetoggle", "modetoggle"},
+		// xsetwacom takes any keysym, also those missing from the table.
+		{"key XF86Copy", "key XF86Copy", "key XF86Copy"},
+		{"key +KP_Home -KP_Home", "key +KP_Home -KP_Home", "key +KP_Home -KP_Home"},
+		{"key +Hyper_L", "key +Hyper_L", "key +Hyper_L"},
+		{"key adiaeresis", "key adiaeresis", "key adiaeresis"},
+		// Keys the table spells differently are kept as they are.
+		{"key +Control_L +Z -Z -Control_L", "key +Control_L +Z -Z -Control_L", "key +Control_L +Z -Z -Control_L"},
+		{"key Prior", "key Prior", "key Prior"},
+	}
+	for _, test := range tests {
+		got, err := ParseButtonAction(test.action)
+		if err != nil {
+			t.Errorf("ParseButtonAction(%q) failed: %v", test.action, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("ParseButtonAction(%q) = %q, want %q", test.action, got, test.want)
+		}
+		if format := FormatButtonAction(got); format != test.format {
+			t.Errorf("FormatButtonAction(%q) = %q, want %q", got, format, test.format)
+		}
+	}
+}
+
+func TestFormatButtonActionRoundTrip(t *testing.T) {
+	for _, action := range []string{
+		"ctrl+z",
+		"ctrl+shift+s",
+		"ctrl+c ctrl+v",
+		"Control_L+z",
+		"F5",
+		"Page_Up",
+		"scroll down",
+		"button 3",
+		"key +ctrl",
+		"key XF86Copy",
+		"key +Control_L +Z -Z -Control_L",
+		"key Prior",
+		"modetoggle",
+	} {
+		parsed, err := ParseButtonAction(action)
+		if err != nil {
+			t.Errorf("ParseButtonAction(%q) failed: %v", action, err)
+		} else if got := FormatButtonAction(parsed); got != action {
+			t.Errorf("FormatButtonAction(ParseButtonAction(%q)) = %q", action, got)
+		}
+	}
+}
+
+func TestParseButtonActionErrors(t *testing.T) {
+	tests := []struct {
+		action string
+		want   string
+	}{
+		{"ctrl+nosuchkey", "Unknown key 'nosuchkey' in 'ctrl+nosuchkey'"},
+		{"ctrl++", "Missing key name in 'ctrl++', use 'plus' for the + key"},
+		{"z+ctrl", "'z' in 'z+ctrl' isn't a modifier like ctrl, shift, alt or super"},
+		{"scroll sideways", "Invalid scroll action 'scroll sideways', expected scroll up|down|left|right"},
+		{"key +ctrl +", "Missing key name in '+'"},
+		{"key", "'key' needs at least one argument"},
+		{"button 99", "Invalid button number '99'"},
+		{"modetoggle 1", "'modetoggle' takes no arguments, got '1'"},
+	}
+	for _, test := range tests {
+		if got, err := ParseButtonAction(test.action); err == nil || err.Error() != test.want {
+			t.Errorf("ParseButtonAction(%q) = %q, %v, want error %q", test.action, got, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package inputs
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// DryRun makes the functions changing devices, like MapToArea and
+// MapButtons, print the commands they would run instead of running them.
+var DryRun = false
+
+var shellSafe = regexp.MustCompile(`^[A-Za-z0-9@%+=:,./_-]+$`)
+
+// ShellQuote joins args to a command line a POSIX shell splits back into
+// args, quoting those with spaces or special characters.
+func ShellQuote(args ...string) string {
+	quoted := make([]string, 0, len(args))
+	for _, arg := range args {
+		if !shellSafe.MatchString(arg) {
+			arg = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+		}
+		quoted = append(quoted, arg)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// run runs a command changing a device and returns its combined output. In
+// dry-run mode it prints the command instead.
+func run(name string, args ...string) ([]byte, error) {
+	if DryRun {
+		fmt.Println(ShellQuote(append([]string{name}, args...)...))
+		return nil, nil
+	}
+	return exec.Command(name, args...).CombinedOutput()
+}
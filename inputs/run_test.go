@@ -0,0 +1,42 @@
+package inputs
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestShellQuote(t *testing.T) {
+	tests := []struct {
+		arg  string
+		want string
+	}{
+		{"xsetwacom", "xsetwacom"},
+		{"HUION_H420:Pen-stylus/1.0,x=5%", "HUION_H420:Pen-stylus/1.0,x=5%"},
+		{"", "''"},
+		{"HUION H420 Pen stylus", "'HUION H420 Pen stylus'"},
+		{"it's", `'it'\''s'`},
+		{"$HOME", "'$HOME'"},
+		{"`id`", "'`id`'"},
+		{`a\b"c`, `'a\b"c'`},
+	}
+	for _, test := range tests {
+		if got := ShellQuote(test.arg); got != test.want {
+			t.Errorf("ShellQuote(%q) = %s, want %s", test.arg, got, test.want)
+		}
+	}
+}
+
+func TestShellQuoteRoundTrip(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no sh")
+	}
+	args := []string{"", "HUION H420 Pen stylus", "it's", "$HOME", "`id`", "a\nb", "*"}
+	out, err := exec.Command("sh", "-c", "printf '%s|' "+ShellQuote(args...)).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := strings.Join(args, "|") + "|"; string(out) != want {
+		t.Errorf("sh split %s into %q, want %q", ShellQuote(args...), out, want)
+	}
+}
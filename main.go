@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"io/fs"
 	"log"
@@ -8,86 +9,119 @@ import (
 	"sort"
 	tm_config "tablet_mapper/config"
 	tm_inputs "tablet_mapper/inputs"
+	"tablet_mapper/outputs"
 	"tablet_mapper/windows"
 
 	gui "github.com/gen2brain/raylib-go/raygui"
 	rl "github.com/gen2brain/raylib-go/raylib"
 )
 
+var rotateOptions = []int{0, 90, 180, 270}
+
+var clipOptions = []tm_inputs.InputClipMode{tm_inputs.INPUT_CLIP_NONE, tm_inputs.INPUT_CLIP_SCREEN, tm_inputs.INPUT_CLIP_MONITOR}
+
 func main() {
-	windowList := windows.GetWindowList()
-	rl.SetTraceLogLevel(rl.LogNone)
-	rl.SetConfigFlags(rl.FlagWindowResizable)
-	rl.SetConfigFlags(rl.FlagMsaa4xHint)
+	profileName := flag.String("profile", "", "use this profile instead of the active one")
+	flag.Usage = printUsage
+	flag.Parse()
 
-	args := os.Args
-	climode := false
-	if len(args) > 1 {
-		log.Printf("INFO: using cli mode as arguments are passed")
-		if args[1] == "-h" {
-			fmt.Printf("Usage: \n %s <config-file-path>\n", args[0])
-			return
-		} else {
-			climode = true
+	if err := runCommand(flag.Args(), *profileName); err != nil {
+		if code := exitCode(err); code != EXIT_OK {
+			log.Printf("ERROR: %s", err.Error())
+			os.Exit(code)
 		}
 	}
-    if (climode) {
-	    rl.SetConfigFlags(rl.FlagWindowHidden)
-    }
-	rl.InitWindow(800, 800, "Tablet Mapper")
-	defer rl.CloseWindow()
-
-	var inputs []tm_inputs.Input
-	var err error
-
-	var config tm_config.TabletMapperConfig
+}
 
-	var confPath string
+// currentWindowMatrix maps to the area of the tablet mapper window.
+func currentWindowMatrix(layout outputs.Layout) tm_inputs.CoordinationMatrix {
+	position := rl.GetWindowPosition()
+	rect := outputs.Rect{X: int(position.X), Y: int(position.Y), Width: rl.GetRenderWidth(), Height: rl.GetRenderHeight()}
+	return layout.GetCoordMappingForRect(rect)
+}
 
-	if climode {
-		confPath = args[1]
-	} else {
-		if confPath, err = tm_config.GetDefaultConfpath(); err != nil {
-			log.Printf("WARN: Couldn't load config %s", err.Error())
+// drawLayoutDiagram draws the outputs of layout scaled into bounds, with the
+// selected one and the highlighted area marked. It returns the name of the
+// output clicked on, or selected if none was.
+func drawLayoutDiagram(layout outputs.Layout, bounds rl.Rectangle, selected string, highlight *outputs.Rect) string {
+	if layout.Width == 0 || layout.Height == 0 {
+		gui.Label(bounds, "No monitor layout")
+		return selected
+	}
+	scale := min(bounds.Width/float32(layout.Width), bounds.Height/float32(layout.Height))
+	toDiagram := func(rect outputs.Rect) rl.Rectangle {
+		return rl.NewRectangle(bounds.X+float32(rect.X)*scale, bounds.Y+float32(rect.Y)*scale,
+			float32(rect.Width)*scale, float32(rect.Height)*scale)
+	}
+	for _, output := range layout.Outputs {
+		rect := toDiagram(output.Rect)
+		color := rl.LightGray
+		if output.Name == selected {
+			color = rl.SkyBlue
 		}
+		rl.DrawRectangleRec(rect, color)
+		rl.DrawRectangleLinesEx(rect, 1, rl.DarkGray)
+		gui.Label(rl.NewRectangle(rect.X+4, rect.Y+4, rect.Width-8, 20), output.Name)
+		if rl.IsMouseButtonPressed(rl.MouseButtonLeft) && rl.CheckCollisionPointRec(rl.GetMousePosition(), rect) {
+			selected = output.Name
+		}
+	}
+	if highlight != nil {
+		rl.DrawRectangleRec(toDiagram(*highlight), rl.Fade(rl.Blue, 0.4))
+	}
+	return selected
+}
 
+// mapToRegion maps the selected inputs to region and stores it in their
+// config. It returns the part of the screen mapped to.
+func mapToRegion(inputs []tm_inputs.Input, config tm_config.TabletMapperConfig, region string, rotation int) (*outputs.Rect, error) {
+	layout, err := outputs.GetLayout()
+	if err != nil {
+		return nil, err
 	}
-	if config, err = tm_config.ReadConfigFromFile(confPath); err != nil {
-		log.Printf("WARN: Couldn't load config %s", err.Error())
-		config = tm_config.TabletMapperConfig{}
+	parsed, err := outputs.ParseRegion(region)
+	if err != nil {
+		return nil, err
 	}
-	if inputs, err = tm_inputs.GetInputs(); err != nil {
-		log.Fatalf("ERROR: Couldn't read inputs %s", err.Error())
-        inputs = make([]tm_inputs.Input, 0)
+	rect, err := layout.RegionRect(parsed)
+	if err != nil {
+		return nil, err
+	}
+	coordMatrix := layout.GetCoordMappingForRect(rect).MultiplyCoordMatrices(tm_inputs.GetCoordinateMatrix(rotation))
+	for i := range inputs {
+		if !inputs[i].Selected {
+			continue
+		}
+		if err := inputs[i].MapToArea(coordMatrix); err != nil {
+			log.Printf("WARN: couldn't map '%s'. %s", inputs[i].Name, err.Error())
+			continue
+		}
+		inputs[i].Config.MappingType = tm_inputs.INPUT_MAPPING_REGION
+		inputs[i].Config.Region = region
+		inputs[i].Config.Rotation = rotation
+		config[inputs[i].Name] = inputs[i].Config
 	}
+	return &rect, nil
+}
+
+// runGUI applies the profile and opens the window to change the mapping.
+func runGUI(s *session) {
+	windowList := windows.GetWindowList()
+	rl.SetTraceLogLevel(rl.LogNone)
+	rl.SetConfigFlags(rl.FlagWindowResizable)
+	rl.SetConfigFlags(rl.FlagMsaa4xHint)
+	rl.InitWindow(800, 800, "Tablet Mapper")
+	defer rl.CloseWindow()
+
+	inputs, doc, confPath := s.inputs, s.doc, s.confPath
+	activeProfile, config := s.profileName, s.devices
 
     log.Printf("Window list %v", windowList)
     log.Printf("Input list %v", inputs)
 
-	for i := 0; i < len(inputs); i++ {
-        if config, ok := config[inputs[i].Name]; ok {
-            inputs[i].Config = config
-            input := inputs[i]
-            log.Printf("Input config: %v", input.Config)
-            if input.Config.MappingType == tm_inputs.INPUT_MAPPING_COORD_MATRIX {
-                input.MapToArea(input.Config.CoordMatrix)
-            } else if input.Config.MappingType == tm_inputs.INPUT_MAPPING_WINDOW {
-                for _, window := range windowList {
-                    if window.AppName == input.Config.WindowName {
-                        log.Printf("Mapping to window %s", window)
-                        coordMatrix := window.GetCoordMappingForWindow()
-                        log.Printf("window coordinates %v", coordMatrix)
-                        coordMatrix = coordMatrix.MultiplyCoordMatrices(tm_inputs.GetCoordinateMatrix(input.Config.Rotation))
-                        log.Printf("transformed coordinates %v", coordMatrix)
-                        input.MapToArea(coordMatrix)
-                    }
-                }
-            }
-        }
-	}
-
-	if climode {
-		return
+	appliedAreas, err := applyDevices(inputs, config)
+	if err != nil {
+		log.Printf("WARN: %s", err.Error())
 	}
 
 	fontFilePath := ".temp.ttf"
@@ -106,9 +140,37 @@ func main() {
 	var selectedWindow int32
 	windowEditMode := false
 	rotate := 0
-	rotateOptions := []int{0, 90, 180, 270}
+	clip := 0
+	// regionOutput is the output picked in the layout diagram, empty for
+	// the whole desktop.
+	regionOutput := ""
+	var mappedRegion *outputs.Rect
+	layout, err := outputs.GetLayout()
+	if err != nil {
+		log.Printf("WARN: %s", err.Error())
+	}
+
+	configChanges := make(chan struct{}, 1)
+	go func() {
+		if err := tm_config.WatchConfig(confPath, configChanges); err != nil {
+			log.Printf("WARN: %s, the config won't be reloaded on changes", err.Error())
+		}
+	}()
 
 	for !rl.WindowShouldClose() {
+		select {
+		case <-configChanges:
+			if newDoc, name, profile, ok := reloadConfig(confPath, s.profileFlag, s.explicitProfile); ok {
+				// Keep the profile selected in the GUI while it exists.
+				if selected, exists := newDoc.Profiles[activeProfile]; exists {
+					name, profile = activeProfile, selected
+				}
+				doc, activeProfile, config = newDoc, name, profile.Devices
+				applyChangedDevices(inputs, config, appliedAreas)
+			}
+		default:
+		}
+
 		if rl.IsWindowResized() {
 			curr_width := rl.GetRenderWidth()
 			new_height := int(float32(curr_width) * (float32(2.23) / float32(4.0)))
@@ -134,11 +196,24 @@ func main() {
 
 			for _, key := range keys {
 				y += 25.0
-				gui.Label(rl.NewRectangle(x+10, y, 200, 20), fmt.Sprintf("Button %s: '%s'", key, inputs[i].Config.Buttons[key]))
+				gui.Label(rl.NewRectangle(x+10, y, 200, 20), fmt.Sprintf("Button %s: '%s'", key, tm_inputs.FormatButtonAction(inputs[i].Config.Buttons[key])))
 			}
 		}
 		y += 30.0
 
+		gui.Label(rl.NewRectangle(x, y, 140, 30), "Profile")
+		for i, name := range doc.ProfileNames() {
+			if gui.Toggle(rl.NewRectangle(140+x+float32(i*130), y, 120, 30), name, name == activeProfile) && name != activeProfile {
+				log.Printf("INFO: switching to profile '%s'", name)
+				activeProfile = name
+				config = doc.Profiles[name].Devices
+				if appliedAreas, err = applyDevices(inputs, config); err != nil {
+					log.Printf("WARN: %s", err.Error())
+				}
+			}
+		}
+		y += 40
+
 		gui.Label(rl.NewRectangle(x, y, 140, 30), "Rotation (degrees)")
 		for i, value := range rotateOptions {
 			selected := gui.Toggle(rl.NewRectangle(140+x+float32(i*50), y, 40, 30), fmt.Sprintf("%d", value), rotate == i)
@@ -148,10 +223,27 @@ func main() {
 		}
 		y += 40
 
+		gui.Label(rl.NewRectangle(x, y, 140, 30), "Clip window to")
+		for i, value := range clipOptions {
+			label := string(value)
+			if value == tm_inputs.INPUT_CLIP_NONE {
+				label = "none"
+			}
+			if gui.Toggle(rl.NewRectangle(140+x+float32(i*80), y, 70, 30), label, clip == i) {
+				clip = i
+			}
+		}
+		y += 40
+
 		if mapArea := gui.Button(rl.NewRectangle(x, y, 200, 40), "Map Current Area"); mapArea {
 			for _, input := range inputs {
 				if input.Selected {
-					coordMatrix := windows.GetCoordMappingFromCurrentWindow()
+					layout, err := outputs.GetLayout()
+					if err != nil {
+						log.Printf("WARN: %s", err.Error())
+						continue
+					}
+					coordMatrix := currentWindowMatrix(layout)
 					coordMatrix = coordMatrix.MultiplyCoordMatrices(tm_inputs.GetCoordinateMatrix(rotateOptions[rotate]))
 					if err := input.MapToArea(coordMatrix); err != nil {
 					}
@@ -182,6 +274,9 @@ func main() {
 
 		if gui.Button(rl.NewRectangle(x+205, y, 40, 40), "(R)") {
 			windowList = windows.GetWindowList()
+			if layout, err = outputs.GetLayout(); err != nil {
+				log.Printf("WARN: %s", err.Error())
+			}
 		}
 
 		if gui.Button(rl.NewRectangle(x+250, y, 200, 40), "Map to Window") {
@@ -190,9 +285,21 @@ func main() {
 
             for _, window := range windowList {
                 if window.AppName == windowName {
-                    log.Printf("INFO: mapping to window %+v", window)
-                    coordMatrix := window.GetCoordMappingForWindow()
-                    coordMatrix = coordMatrix.MultiplyCoordMatrices(tm_inputs.GetCoordinateMatrix(rotateOptions[rotate]))
+                    target := tm_inputs.MappingTarget{
+                        MappingType: tm_inputs.INPUT_MAPPING_WINDOW,
+                        WindowName:  window.AppName,
+                        Rotation:    rotateOptions[rotate],
+                        Clip:        clipOptions[clip],
+                    }
+                    layout, err := outputs.GetLayout()
+                    if err != nil {
+                        log.Printf("WARN: %s", err.Error())
+                    }
+                    coordMatrix, err := windowMatrix(window, target, layout)
+                    if err != nil {
+                        log.Printf("WARN: couldn't map to window. %s", err.Error())
+                        continue
+                    }
                     for i := 0; i < len(inputs); i++ {
                         if inputs[i].Selected {
                             if err := inputs[i].MapToArea(coordMatrix); err != nil {
@@ -201,6 +308,7 @@ func main() {
                             inputs[i].Config.Rotation = rotateOptions[rotate]
                             inputs[i].Config.MappingType = tm_inputs.INPUT_MAPPING_WINDOW
                             inputs[i].Config.WindowName = window.AppName
+                            inputs[i].Config.Clip = clipOptions[clip]
                             config[inputs[i].Name] = inputs[i].Config
                             if err := inputs[i].MapButtons(); err != nil {
                             }
@@ -211,22 +319,28 @@ func main() {
             
 		}
 		y += 50.0
-		if gui.Button(rl.NewRectangle(x, y, 200, 40), "Load Config") {
-			config, _ := tm_config.ReadConfigFromFile(confPath)
-			for i := range inputs {
-				input := &inputs[i]
-				if input.Selected {
-					input.Config = config[input.Name]
-					if err := input.MapToArea(input.Config.CoordMatrix); err != nil {
-					}
-					if err := input.MapButtons(); err != nil {
-					}
+
+		gui.Label(rl.NewRectangle(x, y, 140, 30), "Map to region")
+		if gui.Toggle(rl.NewRectangle(x, y+35, 120, 30), "Whole desktop", regionOutput == "") {
+			regionOutput = ""
+		}
+		regionOutput = drawLayoutDiagram(layout, rl.NewRectangle(x+140, y, 200, 140), regionOutput, mappedRegion)
+		for i, preset := range outputs.RegionPresets {
+			if gui.Button(rl.NewRectangle(x+350+float32(i%3*125), y+float32(i/3*35), 120, 30), preset.Name) {
+				region := outputs.RegionSpec(regionOutput, preset.Name)
+				if rect, err := mapToRegion(inputs, config, region, rotateOptions[rotate]); err != nil {
+					log.Printf("WARN: couldn't map to region %s. %s", region, err.Error())
+				} else {
+					mappedRegion = rect
 				}
 			}
 		}
+		y += float32((len(outputs.RegionPresets)+2)/3*35) + 15
 
-		if gui.Button(rl.NewRectangle(x+250, y, 200, 40), "Save Current Config") {
-			tm_config.WriteConfig(config)
+		if gui.Button(rl.NewRectangle(x, y, 200, 40), "Save Current Config") {
+			if err := tm_config.WriteConfig(confPath, activeProfile, config); err != nil {
+				log.Printf("ERROR: %s", err.Error())
+			}
 		}
 
 		dropdown()
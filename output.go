@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// OutputFormat is how the listing and status commands print their results.
+type OutputFormat string
+
+const (
+	OUTPUT_TABLE OutputFormat = "table"
+	OUTPUT_JSON  OutputFormat = "json"
+)
+
+func (format *OutputFormat) String() string {
+	return string(*format)
+}
+
+func (format *OutputFormat) Set(value string) error {
+	switch OutputFormat(value) {
+	case OUTPUT_TABLE, OUTPUT_JSON:
+		*format = OutputFormat(value)
+		return nil
+	}
+	return fmt.Errorf("unknown output format '%s', expected table or json", value)
+}
+
+// outputFlag adds --output to flags.
+func outputFlag(flags *flag.FlagSet) *OutputFormat {
+	format := OUTPUT_TABLE
+	flags.Var(&format, "output", "print the result as table or json")
+	return &format
+}
+
+// printJSON prints value as indented JSON on stdout.
+func printJSON(value any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(value)
+}
+
+// printTable prints rows as aligned columns under header. Empty cells are
+// printed as "-", so every line has the same number of fields.
+func printTable(header []string, rows [][]string) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+	for _, row := range rows {
+		cells := make([]string, len(row))
+		for i, cell := range row {
+			if cell == "" {
+				cell = "-"
+			}
+			cells[i] = cell
+		}
+		fmt.Fprintln(w, strings.Join(cells, "\t"))
+	}
+	return w.Flush()
+}
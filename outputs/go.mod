@@ -0,0 +1,3 @@
+module tablet_mapper/outputs
+
+go 1.21.5
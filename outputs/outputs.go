@@ -0,0 +1,287 @@
+package outputs
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os/exec"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"tablet_mapper/inputs"
+)
+
+type Rect struct {
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+type Output struct {
+	Name    string `json:"name"`
+	Primary bool   `json:"primary"`
+	Rect    Rect   `json:"rect"`
+	// EDID identifies the connected monitor, see edidIdentity.
+	EDID string `json:"edid"`
+}
+
+// Layout is the X screen as reported by xrandr. Outputs only contains the
+// outputs which are connected and active.
+type Layout struct {
+	Width   int
+	Height  int
+	Outputs []Output
+}
+
+func GetLayout() (Layout, error) {
+	return getLayout("--prop")
+}
+
+// GetCurrentLayout is like GetLayout but doesn't make the X server probe
+// the outputs for changes, which can be slow.
+func GetCurrentLayout() (Layout, error) {
+	return getLayout("--current", "--prop")
+}
+
+func getLayout(args ...string) (Layout, error) {
+	cmd := exec.Command("xrandr", args...)
+	out, err := cmd.Output()
+	if err != nil {
+		return Layout{}, fmt.Errorf("Couldn't read screen layout %w", err)
+	}
+	return parseLayout(string(out))
+}
+
+func parseLayout(text string) (Layout, error) {
+	layout := Layout{Outputs: make([]Output, 0)}
+	var output *Output
+	readingEdid := false
+	edid := strings.Builder{}
+	finishOutput := func() {
+		if output == nil {
+			return
+		}
+		if edid.Len() > 0 {
+			output.EDID = edidIdentity(edid.String())
+			edid.Reset()
+		}
+		layout.Outputs = append(layout.Outputs, *output)
+		output = nil
+	}
+
+	for _, line := range strings.Split(text, "\n") {
+		if strings.HasPrefix(line, "\t\t") {
+			if readingEdid {
+				edid.WriteString(strings.TrimSpace(line))
+			}
+			continue
+		}
+		readingEdid = false
+		if line == "" || line[0] == ' ' {
+			continue
+		}
+		if line[0] == '\t' {
+			readingEdid = strings.TrimSpace(line) == "EDID:"
+			continue
+		}
+
+		finishOutput()
+		fields := strings.Fields(line)
+		if fields[0] == "Screen" {
+			for i := 0; i+3 < len(fields); i++ {
+				if strings.TrimSuffix(fields[i], ",") == "current" {
+					layout.Width, _ = strconv.Atoi(fields[i+1])
+					layout.Height, _ = strconv.Atoi(strings.TrimSuffix(fields[i+3], ","))
+				}
+			}
+			continue
+		}
+		if len(fields) < 2 || fields[1] != "connected" {
+			continue
+		}
+		connected := Output{Name: fields[0]}
+		active := false
+		for _, field := range fields[2:] {
+			if field == "primary" {
+				connected.Primary = true
+			} else if rect, err := ParseGeometry(field); err == nil {
+				connected.Rect = rect
+				active = true
+				break
+			}
+		}
+		if active {
+			output = &connected
+		} else {
+			log.Printf("INFO: skipping inactive output %s", connected.Name)
+		}
+	}
+	finishOutput()
+	if layout.Width == 0 || layout.Height == 0 {
+		return layout, fmt.Errorf("Couldn't find the screen size in xrandr output")
+	}
+	return layout, nil
+}
+
+// edidIdentity decodes the manufacturer, product code and serial number of
+// a monitor from its hex encoded EDID, e.g. "DEL-a0b3-4c4c4c4c".
+func edidIdentity(edidHex string) string {
+	edid, err := hex.DecodeString(edidHex)
+	if err != nil || len(edid) < 16 {
+		log.Printf("WARN: couldn't decode EDID '%s'", edidHex)
+		return ""
+	}
+	manufacturer := uint16(edid[8])<<8 | uint16(edid[9])
+	letters := []byte{
+		byte('A' - 1 + (manufacturer>>10)&0x1f),
+		byte('A' - 1 + (manufacturer>>5)&0x1f),
+		byte('A' - 1 + manufacturer&0x1f),
+	}
+	product := binary.LittleEndian.Uint16(edid[10:12])
+	serial := binary.LittleEndian.Uint32(edid[12:16])
+	return fmt.Sprintf("%s-%04x-%08x", letters, product, serial)
+}
+
+func (output Output) Resolution() string {
+	return fmt.Sprintf("%dx%d", output.Rect.Width, output.Rect.Height)
+}
+
+// Fingerprint identifies the set of active outputs with their monitors and
+// resolutions, independent of the order xrandr lists them in.
+func (layout Layout) Fingerprint() string {
+	parts := make([]string, 0, len(layout.Outputs))
+	for _, output := range layout.Outputs {
+		parts = append(parts, fmt.Sprintf("%s=%s@%s", output.Name, output.EDID, output.Resolution()))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+// ParseGeometry parses an X geometry string of the form WxH+X+Y.
+func ParseGeometry(geometry string) (Rect, error) {
+	var rect Rect
+	if _, err := fmt.Sscanf(geometry, "%dx%d+%d+%d", &rect.Width, &rect.Height, &rect.X, &rect.Y); err != nil {
+		return Rect{}, fmt.Errorf("Invalid geometry '%s' %w", geometry, err)
+	}
+	return rect, nil
+}
+
+// Geometry formats rect as an X geometry string, the inverse of
+// ParseGeometry.
+func (rect Rect) Geometry() string {
+	return fmt.Sprintf("%dx%d+%d+%d", rect.Width, rect.Height, rect.X, rect.Y)
+}
+
+// FindOutput returns the output with the given name, or the primary output
+// if name is empty.
+func (layout Layout) FindOutput(name string) (Output, bool) {
+	for _, output := range layout.Outputs {
+		if (name == "" && output.Primary) || (name != "" && output.Name == name) {
+			return output, true
+		}
+	}
+	if name == "" && len(layout.Outputs) > 0 {
+		return layout.Outputs[0], true
+	}
+	return Output{}, false
+}
+
+// GetCoordMappingForRect builds the transformation matrix mapping the whole
+// tablet surface to rect.
+func (layout Layout) GetCoordMappingForRect(rect Rect) inputs.CoordinationMatrix {
+	screenWidth := float32(layout.Width)
+	screenHeight := float32(layout.Height)
+	return inputs.CoordinationMatrix{
+		{float32(rect.Width) / screenWidth, 0.0, float32(rect.X) / screenWidth},
+		{0.0, float32(rect.Height) / screenHeight, float32(rect.Y) / screenHeight},
+		{0.0, 0.0, 1.0},
+	}
+}
+
+func (rect Rect) Area() int {
+	return rect.Width * rect.Height
+}
+
+// Intersect returns the part of rect inside other. ok is false when they
+// don't overlap.
+func (rect Rect) Intersect(other Rect) (Rect, bool) {
+	x1 := max(rect.X, other.X)
+	y1 := max(rect.Y, other.Y)
+	x2 := min(rect.X+rect.Width, other.X+other.Width)
+	y2 := min(rect.Y+rect.Height, other.Y+other.Height)
+	if x2 <= x1 || y2 <= y1 {
+		return Rect{}, false
+	}
+	return Rect{X: x1, Y: y1, Width: x2 - x1, Height: y2 - y1}, true
+}
+
+// ClipToOutputs returns the largest part of rect which is shown on the
+// outputs without gaps. With monitors of different heights side by side,
+// that leaves out the strip next to the smaller one. ok is false when no
+// part of rect is visible.
+func (layout Layout) ClipToOutputs(rect Rect) (Rect, bool) {
+	parts := make([]Rect, 0, len(layout.Outputs))
+	xs, ys := make([]int, 0), make([]int, 0)
+	for _, output := range layout.Outputs {
+		if part, visible := rect.Intersect(output.Rect); visible {
+			parts = append(parts, part)
+			xs = append(xs, part.X, part.X+part.Width)
+			ys = append(ys, part.Y, part.Y+part.Height)
+		}
+	}
+	if len(parts) == 0 {
+		return Rect{}, false
+	}
+	sort.Ints(xs)
+	sort.Ints(ys)
+	xs, ys = slices.Compact(xs), slices.Compact(ys)
+	// The edges of the parts split the screen into cells which are either
+	// shown completely or not at all.
+	shown := func(x1, y1, x2, y2 int) bool {
+		for _, part := range parts {
+			if part.X <= x1 && x2 <= part.X+part.Width && part.Y <= y1 && y2 <= part.Y+part.Height {
+				return true
+			}
+		}
+		return false
+	}
+	covered := func(left, top, right, bottom int) bool {
+		for i := left; i < right; i++ {
+			for j := top; j < bottom; j++ {
+				if !shown(xs[i], ys[j], xs[i+1], ys[j+1]) {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	clipped := Rect{}
+	for left := range xs {
+		for right := left + 1; right < len(xs); right++ {
+			for top := range ys {
+				for bottom := top + 1; bottom < len(ys); bottom++ {
+					candidate := Rect{X: xs[left], Y: ys[top], Width: xs[right] - xs[left], Height: ys[bottom] - ys[top]}
+					if candidate.Area() > clipped.Area() && covered(left, top, right, bottom) {
+						clipped = candidate
+					}
+				}
+			}
+		}
+	}
+	return clipped, true
+}
+
+// ClipToLargestOutput returns the part of rect on the output showing most
+// of it. ok is false when no part of rect is visible.
+func (layout Layout) ClipToLargestOutput(rect Rect) (Rect, bool) {
+	clipped, ok := Rect{}, false
+	for _, output := range layout.Outputs {
+		if part, visible := rect.Intersect(output.Rect); visible && part.Area() > clipped.Area() {
+			clipped, ok = part, true
+		}
+	}
+	return clipped, ok
+}
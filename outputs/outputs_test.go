@@ -0,0 +1,67 @@
+package outputs
+
+import (
+	"testing"
+)
+
+func TestClipToOutputs(t *testing.T) {
+	// A landscape monitor left of a vertical one.
+	vertical := Layout{Width: 3000, Height: 1920, Outputs: []Output{
+		{Name: "DP-2", Rect: Rect{X: 0, Y: 0, Width: 1920, Height: 1080}},
+		{Name: "HDMI-1", Rect: Rect{X: 1920, Y: 0, Width: 1080, Height: 1920}},
+	}}
+	// Two landscape monitors of different heights, aligned at the top.
+	uneven := Layout{Width: 4480, Height: 1440, Outputs: []Output{
+		{Name: "DP-2", Rect: Rect{X: 0, Y: 0, Width: 2560, Height: 1440}},
+		{Name: "HDMI-1", Rect: Rect{X: 2560, Y: 0, Width: 1920, Height: 1080}},
+	}}
+	tests := []struct {
+		name        string
+		layout      Layout
+		window      Rect
+		wantOutputs Rect
+		wantLargest Rect
+		wantOk      bool
+	}{
+		{
+			name:        "inside one monitor",
+			layout:      uneven,
+			window:      Rect{X: 100, Y: 100, Width: 800, Height: 600},
+			wantOutputs: Rect{X: 100, Y: 100, Width: 800, Height: 600},
+			wantLargest: Rect{X: 100, Y: 100, Width: 800, Height: 600},
+			wantOk:      true,
+		},
+		{
+			name:        "overlapping a vertical monitor",
+			layout:      vertical,
+			window:      Rect{X: 1500, Y: 200, Width: 1000, Height: 1500},
+			wantOutputs: Rect{X: 1500, Y: 200, Width: 1000, Height: 880},
+			wantLargest: Rect{X: 1920, Y: 200, Width: 580, Height: 1500},
+			wantOk:      true,
+		},
+		{
+			name:        "spanning monitors of different heights",
+			layout:      uneven,
+			window:      Rect{X: 2000, Y: 500, Width: 1200, Height: 800},
+			wantOutputs: Rect{X: 2000, Y: 500, Width: 1200, Height: 580},
+			wantLargest: Rect{X: 2000, Y: 500, Width: 560, Height: 800},
+			wantOk:      true,
+		},
+		{
+			name:   "off screen",
+			layout: uneven,
+			window: Rect{X: 5000, Y: 0, Width: 100, Height: 100},
+			wantOk: false,
+		},
+	}
+	for _, test := range tests {
+		got, ok := test.layout.ClipToOutputs(test.window)
+		if ok != test.wantOk || got != test.wantOutputs {
+			t.Errorf("%s: ClipToOutputs = %+v, %v, want %+v, %v", test.name, got, ok, test.wantOutputs, test.wantOk)
+		}
+		got, ok = test.layout.ClipToLargestOutput(test.window)
+		if ok != test.wantOk || got != test.wantLargest {
+			t.Errorf("%s: ClipToLargestOutput = %+v, %v, want %+v, %v", test.name, got, ok, test.wantLargest, test.wantOk)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package outputs
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Length is a distance in pixels or, if Percent is set, in percent of the
+// width or height of the area a region is placed in.
+type Length struct {
+	Value   float64
+	Percent bool
+}
+
+// Region is a part of the screen as described by ParseRegion. Its position
+// and size are relative to the output named Output, to the primary output
+// if Output is "primary", or to the whole screen if Output is empty.
+type Region struct {
+	Output string
+	X      Length
+	Y      Length
+	Width  Length
+	Height Length
+}
+
+const REGION_PRIMARY = "primary"
+
+var (
+	lengthPattern   = `(\d+(?:\.\d+)?%?)`
+	geometryPattern = regexp.MustCompile(`^` + lengthPattern + `x` + lengthPattern + `(?:\+` + lengthPattern + `\+` + lengthPattern + `)?$`)
+	gridPattern     = regexp.MustCompile(`^(\d+)x(\d+)$`)
+)
+
+// RegionPreset is a named area like "left-half", usable on every output
+// and the whole screen.
+type RegionPreset struct {
+	Name   string
+	Region Region
+}
+
+func percent(value float64) Length {
+	return Length{Value: value, Percent: true}
+}
+
+func presetArea(x, y, width, height float64) Region {
+	return Region{X: percent(x), Y: percent(y), Width: percent(width), Height: percent(height)}
+}
+
+const third = 100.0 / 3
+
+// RegionPresets are the named areas, in the order they are offered in.
+var RegionPresets = []RegionPreset{
+	{"full", presetArea(0, 0, 100, 100)},
+	{"center", presetArea(25, 25, 50, 50)},
+	{"left-half", presetArea(0, 0, 50, 100)},
+	{"right-half", presetArea(50, 0, 50, 100)},
+	{"top-half", presetArea(0, 0, 100, 50)},
+	{"bottom-half", presetArea(0, 50, 100, 50)},
+	{"left-third", presetArea(0, 0, third, 100)},
+	{"center-third", presetArea(third, 0, third, 100)},
+	{"right-third", presetArea(2*third, 0, third, 100)},
+	{"left-two-thirds", presetArea(0, 0, 2*third, 100)},
+	{"right-two-thirds", presetArea(third, 0, 2*third, 100)},
+	{"top-left", presetArea(0, 0, 50, 50)},
+	{"top-right", presetArea(50, 0, 50, 50)},
+	{"bottom-left", presetArea(0, 50, 50, 50)},
+	{"bottom-right", presetArea(50, 50, 50, 50)},
+}
+
+func parseLength(text string) (Length, error) {
+	length := Length{}
+	if number, ok := strings.CutSuffix(text, "%"); ok {
+		text, length.Percent = number, true
+	}
+	value, err := strconv.ParseFloat(strings.TrimSpace(text), 64)
+	if err != nil || value < 0 || math.IsInf(value, 0) {
+		return Length{}, fmt.Errorf("invalid length '%s'", text)
+	}
+	length.Value = value
+	return length, nil
+}
+
+// ParseRegion parses a region given as [OUTPUT:]AREA. OUTPUT is the name of
+// an output or "primary", without it the area is placed on the whole
+// screen. AREA is one of
+//
+//	WxH+X+Y       an X geometry, e.g. 800x600+100+50 or 50%x100%+0+0
+//	X,Y,W,H       the same as a list, e.g. 0,0,50%,50%
+//	NAME          a named area like left-half, see RegionPresets
+//	COLSxROWS:N   the Nth cell of a grid, counting from 1 row by row
+//
+// Sizes and offsets are pixels, or percent of the output or screen with a
+// trailing %.
+func ParseRegion(spec string) (Region, error) {
+	parts := strings.Split(spec, ":")
+	region := Region{}
+	area := parts[len(parts)-1]
+	var err error
+	switch {
+	case len(parts) == 3:
+		region.Output = parts[0]
+		err = parseGrid(&region, parts[1], parts[2])
+	case len(parts) == 2 && gridPattern.MatchString(parts[0]):
+		err = parseGrid(&region, parts[0], parts[1])
+	case len(parts) <= 2:
+		if len(parts) == 2 {
+			region.Output = parts[0]
+		}
+		err = parseArea(&region, area)
+	default:
+		err = fmt.Errorf("too many ':'")
+	}
+	if err == nil && len(parts) > 1 && parts[0] == "" {
+		err = fmt.Errorf("missing output name before ':'")
+	}
+	if err != nil {
+		return Region{}, fmt.Errorf("Invalid region '%s': %w", spec, err)
+	}
+	return region, nil
+}
+
+func parseArea(region *Region, area string) error {
+	output := region.Output
+	for _, preset := range RegionPresets {
+		if preset.Name == area {
+			*region = preset.Region
+			region.Output = output
+			return nil
+		}
+	}
+	var lengths []string
+	if match := geometryPattern.FindStringSubmatch(area); match != nil {
+		lengths = []string{match[3], match[4], match[1], match[2]}
+		if lengths[0] == "" {
+			lengths[0], lengths[1] = "0", "0"
+		}
+	} else if fields := strings.Split(area, ","); len(fields) == 4 {
+		lengths = fields
+	} else {
+		return fmt.Errorf("expected WxH+X+Y, X,Y,W,H, a grid cell or a name like left-half")
+	}
+	targets := []*Length{&region.X, &region.Y, &region.Width, &region.Height}
+	for i, text := range lengths {
+		length, err := parseLength(text)
+		if err != nil {
+			return err
+		}
+		*targets[i] = length
+	}
+	if region.Width.Value == 0 || region.Height.Value == 0 {
+		return fmt.Errorf("width and height must be greater than 0")
+	}
+	return nil
+}
+
+// RegionSpec formats a preset on the given output, or on the whole screen
+// if output is empty, as ParseRegion expects it.
+func RegionSpec(output string, preset string) string {
+	if output == "" {
+		return preset
+	}
+	return output + ":" + preset
+}
+
+func parseGrid(region *Region, grid string, cell string) error {
+	match := gridPattern.FindStringSubmatch(grid)
+	if match == nil {
+		return fmt.Errorf("invalid grid '%s', expected COLSxROWS", grid)
+	}
+	columns, _ := strconv.Atoi(match[1])
+	rows, _ := strconv.Atoi(match[2])
+	if columns < 1 || rows < 1 {
+		return fmt.Errorf("grid '%s' has no cells", grid)
+	}
+	n, err := strconv.Atoi(cell)
+	if err != nil || n < 1 || n > columns*rows {
+		return fmt.Errorf("invalid cell '%s', expected 1 to %d", cell, columns*rows)
+	}
+	column, row := (n-1)%columns, (n-1)/columns
+	output := region.Output
+	*region = presetArea(100*float64(column)/float64(columns), 100*float64(row)/float64(rows), 100/float64(columns), 100/float64(rows))
+	region.Output = output
+	return nil
+}
+
+func (length Length) pixels(total int) int {
+	if length.Percent {
+		return int(math.Round(length.Value * float64(total) / 100))
+	}
+	return int(math.Round(length.Value))
+}
+
+// RegionRect returns the part of the screen region covers in this layout.
+func (layout Layout) RegionRect(region Region) (Rect, error) {
+	if layout.Width == 0 || layout.Height == 0 {
+		return Rect{}, fmt.Errorf("Unknown screen size")
+	}
+	base := Rect{Width: layout.Width, Height: layout.Height}
+	if region.Output != "" {
+		name := region.Output
+		if name == REGION_PRIMARY {
+			name = ""
+		}
+		output, ok := layout.FindOutput(name)
+		if !ok {
+			return Rect{}, fmt.Errorf("No connected output '%s'", region.Output)
+		}
+		base = output.Rect
+	}
+	rect := Rect{
+		X:      base.X + region.X.pixels(base.Width),
+		Y:      base.Y + region.Y.pixels(base.Height),
+		Width:  region.Width.pixels(base.Width),
+		Height: region.Height.pixels(base.Height),
+	}
+	if _, ok := rect.Intersect(Rect{Width: layout.Width, Height: layout.Height}); !ok {
+		return Rect{}, fmt.Errorf("Region %s is off screen", rect.Geometry())
+	}
+	return rect, nil
+}
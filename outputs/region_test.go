@@ -0,0 +1,97 @@
+package outputs
+
+import (
+	"testing"
+)
+
+func TestParseRegion(t *testing.T) {
+	pixels := func(value float64) Length { return Length{Value: value} }
+	percent := func(value float64) Length { return Length{Value: value, Percent: true} }
+	tests := []struct {
+		spec string
+		want Region
+	}{
+		{"800x600+100+50", Region{X: pixels(100), Y: pixels(50), Width: pixels(800), Height: pixels(600)}},
+		{"800x600", Region{X: pixels(0), Y: pixels(0), Width: pixels(800), Height: pixels(600)}},
+		{"DP-2:50%x100%+50%+0", Region{Output: "DP-2", X: percent(50), Y: pixels(0), Width: percent(50), Height: percent(100)}},
+		{"0,0,50%,50%", Region{X: pixels(0), Y: pixels(0), Width: percent(50), Height: percent(50)}},
+		{"primary:12.5%,0,25%,100%", Region{Output: REGION_PRIMARY, X: percent(12.5), Y: pixels(0), Width: percent(25), Height: percent(100)}},
+		{"2x2:4", Region{X: percent(50), Y: percent(50), Width: percent(50), Height: percent(50)}},
+		{"HDMI-1:4x1:2", Region{Output: "HDMI-1", X: percent(25), Y: percent(0), Width: percent(25), Height: percent(100)}},
+	}
+	for _, test := range tests {
+		got, err := ParseRegion(test.spec)
+		if err != nil {
+			t.Errorf("ParseRegion(%q) failed: %v", test.spec, err)
+		} else if got != test.want {
+			t.Errorf("ParseRegion(%q) = %+v, want %+v", test.spec, got, test.want)
+		}
+	}
+}
+
+func TestParseRegionErrors(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"nowhere",
+		"0x600+0+0",
+		"800x600+-1+0",
+		"1,2,3",
+		"a:b:c:d",
+		":left-half",
+		"2x2:5",
+		"0x2:1",
+		"DP-2:2x2:0",
+	} {
+		if got, err := ParseRegion(spec); err == nil {
+			t.Errorf("ParseRegion(%q) = %+v, want an error", spec, got)
+		}
+	}
+}
+
+func TestRegionRect(t *testing.T) {
+	layout := Layout{Width: 4480, Height: 1440, Outputs: []Output{
+		{Name: "DP-2", Rect: Rect{Width: 2560, Height: 1440}},
+		{Name: "HDMI-1", Primary: true, Rect: Rect{X: 2560, Width: 1920, Height: 1080}},
+	}}
+	tests := []struct {
+		spec string
+		want Rect
+	}{
+		{"full", Rect{Width: 4480, Height: 1440}},
+		{"left-half", Rect{Width: 2240, Height: 1440}},
+		{"DP-2:right-half", Rect{X: 1280, Width: 1280, Height: 1440}},
+		{"primary:top-half", Rect{X: 2560, Width: 1920, Height: 540}},
+		{"HDMI-1:bottom-half", Rect{X: 2560, Y: 540, Width: 1920, Height: 540}},
+		{"HDMI-1:800x600+100+50", Rect{X: 2660, Y: 50, Width: 800, Height: 600}},
+		{"DP-2:3x1:3", Rect{X: 1707, Width: 853, Height: 1440}},
+	}
+	for _, test := range tests {
+		region, err := ParseRegion(test.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := layout.RegionRect(region)
+		if err != nil {
+			t.Errorf("RegionRect(%q) failed: %v", test.spec, err)
+		} else if got != test.want {
+			t.Errorf("RegionRect(%q) = %+v, want %+v", test.spec, got, test.want)
+		}
+	}
+}
+
+func TestRegionRectErrors(t *testing.T) {
+	layout := Layout{Width: 2560, Height: 1440, Outputs: []Output{{Name: "DP-2", Rect: Rect{Width: 2560, Height: 1440}}}}
+	for _, spec := range []string{"eDP-1:full", "100x100+3000+0"} {
+		region, err := ParseRegion(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := layout.RegionRect(region); err == nil {
+			t.Errorf("RegionRect(%q) = %+v, want an error", spec, got)
+		}
+	}
+	full := Length{Value: 100, Percent: true}
+	if _, err := (Layout{}).RegionRect(Region{Width: full, Height: full}); err == nil {
+		t.Errorf("RegionRect on an unknown screen size succeeded")
+	}
+}
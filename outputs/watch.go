@@ -0,0 +1,51 @@
+package outputs
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// WatchLayoutChanges signals on changes whenever RandR reports a change of
+// the screen layout. A single change of the layout usually results in
+// several signals. It blocks until the underlying xev process exits.
+func WatchLayoutChanges(changes chan<- struct{}) error {
+	cmd := exec.Command("xev", "-root", "-event", "randr")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("Couldn't watch layout changes %w", err)
+	}
+	if err = cmd.Start(); err != nil {
+		return fmt.Errorf("Couldn't watch layout changes %w", err)
+	}
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		if strings.HasPrefix(scanner.Text(), "RR") {
+			changes <- struct{}{}
+		}
+	}
+	return cmd.Wait()
+}
+
+// PollLayoutChanges signals on changes whenever the fingerprint of the
+// layout changes, checking every interval. It is meant for when xev isn't
+// available and never returns.
+func PollLayoutChanges(interval time.Duration, changes chan<- struct{}) {
+	fingerprint := ""
+	for range time.Tick(interval) {
+		layout, err := GetCurrentLayout()
+		if err != nil {
+			log.Printf("WARN: %s", err.Error())
+			continue
+		}
+		if current := layout.Fingerprint(); current != fingerprint {
+			if fingerprint != "" {
+				changes <- struct{}{}
+			}
+			fingerprint = current
+		}
+	}
+}
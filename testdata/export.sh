@@ -0,0 +1,57 @@
+#!/bin/sh
+# Exported by tablet-mapper from profile 'default' of /home/me/.config/tablet-mapper/config.json.
+
+# find_device NAME [VENDOR PRODUCT] prints the xinput id of the device NAME.
+# Without one, it looks for a device with the same USB id, given in decimal,
+# whose name ends with the same word, e.g. "stylus".
+find_device() {
+	if id=$(xinput list --id-only "$1" 2>/dev/null); then
+		echo "$id"
+		return 0
+	fi
+	[ $# -eq 3 ] || return 1
+	for id in $(xinput list --id-only); do
+		case "$(xinput list --name-only "$id")" in
+		*" ${1##* }") ;;
+		*) continue ;;
+		esac
+		if xinput list-props "$id" | grep -q "Device Product ID ([0-9]*):[[:space:]]*$2, $3\$"; then
+			echo "$id"
+			return 0
+		fi
+	done
+	return 1
+}
+
+status=0
+
+# HUION H420 Pen stylus, USB id 256c:006e
+# Note: mapping to window 'krita' needs the daemon, using the fallbacks.
+if id=$(find_device 'HUION H420 Pen stylus' 9580 110); then
+	xsetwacom --set "$id" Mode absolute
+	xsetwacom --set "$id" Area 0 0 16000 10000
+	xinput set-prop "$id" --type=float 'Coordinate Transformation Matrix' 0.500000 0.000000 0.500000 0.000000 1.000000 0.000000 0.000000 0.000000 1.000000
+	xsetwacom --set "$id" Button 2 'key +ctrl +z -z -ctrl'
+	xsetwacom --set "$id" Button 3 'button +1 -1'
+	xsetwacom --set "$id" Button 10 3
+else
+	echo 'HUION H420 Pen stylus isn'\''t connected' >&2
+	status=1
+fi
+
+# HUION H420 Pad pad
+if id=$(find_device 'HUION H420 Pad pad'); then
+	xinput map-to-output "$id" HDMI-1
+else
+	echo 'HUION H420 Pad pad isn'\''t connected' >&2
+	status=1
+fi
+
+# It's a "tablet" $HOME
+if id=$(find_device 'It'\''s a "tablet" $HOME'); then
+	:
+else
+	echo 'It'\''s a "tablet" $HOME isn'\''t connected' >&2
+	status=1
+fi
+exit $status
@@ -1,34 +1,45 @@
 package windows
 
 import (
-	rl "github.com/gen2brain/raylib-go/raylib"
+	"fmt"
 	"log"
 	"os/exec"
 	"strconv"
 	"strings"
 	"tablet_mapper/inputs"
+	"tablet_mapper/outputs"
 )
 
 type Window struct {
-	Id          string
-	DesktopId   int
-	Xoffset     int
-	Yoffset     int
-	Width       int
-	Height      int
-	MachineName string
-	Title       string
-	AppName     string
+	Id          string `json:"id"`
+	DesktopId   int    `json:"desktopId"`
+	Xoffset     int    `json:"xoffset"`
+	Yoffset     int    `json:"yoffset"`
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+	MachineName string `json:"machineName"`
+	Title       string `json:"title"`
+	AppName     string `json:"appName"`
+	Class       string `json:"class"`
 }
 
 func GetWindowList() []Window {
+	windowList, err := ReadWindowList()
+	if err != nil {
+		log.Printf("ERROR: %s", err.Error())
+	}
+	return windowList
+}
 
-	cmd := exec.Command("wmctrl", "-l", "-G")
+// ReadWindowList is like GetWindowList but returns an error if the windows
+// can't be listed.
+func ReadWindowList() ([]Window, error) {
+	cmd := exec.Command("wmctrl", "-l", "-G", "-x")
 	defer cmd.Wait()
 	var out []byte
 	var err error
 	if out, err = cmd.CombinedOutput(); err != nil {
-		log.Printf("ERROR: %s", out)
+		return []Window{}, fmt.Errorf("Couldn't list windows %w %s", err, strings.TrimSpace(string(out)))
 	}
 
 	windowList := make([]Window, 0)
@@ -57,6 +68,7 @@ func GetWindowList() []Window {
 		yoffset, rest := readNextWord(strings.TrimSpace(rest))
 		width, rest := readNextWord(strings.TrimSpace(rest))
 		height, rest := readNextWord(strings.TrimSpace(rest))
+		class, rest := readNextWord(strings.TrimSpace(rest))
 		machineName, rest := readNextWord(strings.TrimSpace(rest))
 		title := strings.TrimSpace(rest)
 
@@ -66,6 +78,7 @@ func GetWindowList() []Window {
 		w.Yoffset, _ = strconv.Atoi(yoffset)
 		w.Width, _ = strconv.Atoi(width)
 		w.Height, _ = strconv.Atoi(height)
+		w.Class = class
 		w.MachineName = machineName
 		w.Title = title
 		chunks := strings.Split(title, "-")
@@ -73,52 +86,97 @@ func GetWindowList() []Window {
 		windowList = append(windowList, w)
 	}
 
-	return windowList
+	return windowList, nil
 }
-func (win Window) GetCoordMappingForWindow() inputs.CoordinationMatrix {
-	x := win.Xoffset
-	y := win.Yoffset
-	w := win.Width
-	h := win.Height
-	monitor_count := rl.GetMonitorCount()
-	screen_width := 0
-	screen_height := 0
-	for i := 0; i < monitor_count; i++ {
-		monitor_pos := rl.GetMonitorPosition(i)
-		if screen_width < rl.GetMonitorWidth(i)+int(monitor_pos.X) {
-			screen_width = rl.GetMonitorWidth(i) + int(monitor_pos.X)
-		}
-		if screen_height < rl.GetMonitorHeight(i)+int(monitor_pos.Y) {
-			screen_height = rl.GetMonitorHeight(i) + int(monitor_pos.Y)
+
+// GetCurrentDesktop returns the number of the desktop currently shown.
+func GetCurrentDesktop() (int, error) {
+	cmd := exec.Command("wmctrl", "-d")
+	out, err := cmd.Output()
+	if err != nil {
+		return 0, fmt.Errorf("Couldn't read desktops %w", err)
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 1 && fields[1] == "*" {
+			return strconv.Atoi(fields[0])
 		}
 	}
+	return 0, fmt.Errorf("Couldn't find the current desktop")
+}
+
+// IsMinimized reports whether the window manager hides the window.
+func (win Window) IsMinimized() bool {
+	cmd := exec.Command("xprop", "-id", win.Id, "_NET_WM_STATE")
+	out, err := cmd.Output()
+	if err != nil {
+		log.Printf("WARN: couldn't read state of window %s. %s", win.Id, err.Error())
+		return false
+	}
+	return strings.Contains(string(out), "_NET_WM_STATE_HIDDEN")
+}
+
+// IsVisible reports whether the window can be seen on desktop. Sticky
+// windows have the desktop id -1 and are shown on every desktop.
+func (win Window) IsVisible(desktop int) bool {
+	if win.DesktopId != -1 && win.DesktopId != desktop {
+		return false
+	}
+	return !win.IsMinimized()
+}
+
+// MatchesClass reports whether the window's WM_CLASS matches class. wmctrl
+// reports WM_CLASS as "instance.Class", so either part or the whole string
+// is accepted, ignoring case.
+func (win Window) MatchesClass(class string) bool {
+	if class == "" {
+		return false
+	}
+	wmClass := strings.ToLower(win.Class)
+	class = strings.ToLower(class)
+	return wmClass == class ||
+		strings.HasPrefix(wmClass, class+".") ||
+		strings.HasSuffix(wmClass, "."+class)
+}
 
-	window_posX := float32(x)
-	window_posY := float32(y)
-	curr_width := float32(w)
-	curr_height := float32(h)
-
-	// c0 = touch_area_width / total_width
-	c0 := curr_width / float32(screen_width)
-	// c2 = touch_area_height / total_height
-	c2 := curr_height / float32(screen_height)
-	// c1 = touch_area_x_offset / total_width
-	c1 := window_posX / float32(screen_width)
-	// c3 = touch_area_y_offset / total_height
-	c3 := window_posY / float32(screen_height)
-
-	return inputs.CoordinationMatrix{{c0, 0.0, c1}, {0.0, c2, c3}, {0.0, 0.0, 1.0}}
+// Matches reports whether the window is selected by match while desktop is
+// shown. A match with only a desktop selects any window on that desktop.
+func (win Window) Matches(match inputs.WindowMatch, desktop int) bool {
+	if match.Desktop != nil && *match.Desktop != desktop {
+		return false
+	}
+	if match.Class == "" && match.Title == "" {
+		return match.Desktop != nil
+	}
+	if match.Class != "" && !win.MatchesClass(match.Class) {
+		return false
+	}
+	if match.Title != "" && !strings.Contains(strings.ToLower(win.Title), strings.ToLower(match.Title)) {
+		return false
+	}
+	return true
 }
 
-func GetCoordMappingFromCurrentWindow() inputs.CoordinationMatrix {
-	curr_height := rl.GetRenderHeight()
-	curr_width := rl.GetRenderWidth()
-	window_pos := rl.GetWindowPosition()
-	window := Window{
-		Xoffset: int(window_pos.X),
-		Yoffset: int(window_pos.Y),
-		Width:   curr_width,
-		Height:  curr_height,
+// FindWindowById looks up a window by its X id. Ids are compared numerically
+// as wmctrl zero pads them while xprop doesn't.
+func FindWindowById(windowList []Window, id string) (Window, bool) {
+	wanted, err := strconv.ParseUint(id, 0, 64)
+	if err != nil {
+		return Window{}, false
+	}
+	for _, w := range windowList {
+		if winId, err := strconv.ParseUint(w.Id, 0, 64); err == nil && winId == wanted {
+			return w, true
+		}
 	}
-	return window.GetCoordMappingForWindow()
+	return Window{}, false
+}
+
+func (win Window) Rect() outputs.Rect {
+	return outputs.Rect{X: win.Xoffset, Y: win.Yoffset, Width: win.Width, Height: win.Height}
+}
+
+// GetCoordMappingForWindow maps the whole tablet surface to the window.
+func (win Window) GetCoordMappingForWindow(layout outputs.Layout) inputs.CoordinationMatrix {
+	return layout.GetCoordMappingForRect(win.Rect())
 }
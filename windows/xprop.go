@@ -0,0 +1,77 @@
+package windows
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+)
+
+const (
+	PROP_ACTIVE_WINDOW   = "_NET_ACTIVE_WINDOW"
+	PROP_CLIENT_LIST     = "_NET_CLIENT_LIST"
+	PROP_CURRENT_DESKTOP = "_NET_CURRENT_DESKTOP"
+)
+
+type RootPropertyChange struct {
+	Name  string
+	Value string
+}
+
+// parseXpropLine splits a line printed by xprop into the property name and
+// its value, e.g. "_NET_ACTIVE_WINDOW(WINDOW): window id # 0x3a00007".
+func parseXpropLine(line string) (RootPropertyChange, bool) {
+	nameEnd := strings.Index(line, "(")
+	if nameEnd <= 0 {
+		return RootPropertyChange{}, false
+	}
+	change := RootPropertyChange{Name: line[:nameEnd]}
+	rest := line[nameEnd:]
+	if idx := strings.Index(rest, "# "); idx >= 0 {
+		change.Value = strings.TrimSpace(rest[idx+2:])
+	} else if idx := strings.Index(rest, "= "); idx >= 0 {
+		change.Value = strings.TrimSpace(rest[idx+2:])
+	} else {
+		return RootPropertyChange{}, false
+	}
+	return change, true
+}
+
+// GetActiveWindowId returns the id of the focused window.
+func GetActiveWindowId() (string, error) {
+	cmd := exec.Command("xprop", "-root", PROP_ACTIVE_WINDOW)
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("Couldn't read the active window %w", err)
+	}
+	change, ok := parseXpropLine(strings.TrimSpace(string(out)))
+	if !ok || change.Value == "0x0" {
+		return "", fmt.Errorf("No window is focused")
+	}
+	return change.Value, nil
+}
+
+// WatchRootProperties sends the current value of the given root window
+// properties and then every change to them on changes. It blocks until the
+// underlying xprop process exits.
+func WatchRootProperties(names []string, changes chan<- RootPropertyChange) error {
+	args := append([]string{"-spy", "-root"}, names...)
+	cmd := exec.Command("xprop", args...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("Couldn't watch root properties %w", err)
+	}
+	if err = cmd.Start(); err != nil {
+		return fmt.Errorf("Couldn't watch root properties %w", err)
+	}
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		if change, ok := parseXpropLine(scanner.Text()); ok {
+			changes <- change
+		} else {
+			log.Printf("WARN: couldn't parse xprop output '%s'", scanner.Text())
+		}
+	}
+	return cmd.Wait()
+}